@@ -0,0 +1,496 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/surajsingh0/go-migrate-easy/migrations"
+)
+
+// command is a subcommand such as "migrate up"
+type command struct {
+	name    string
+	args    string // synopsis of the flags specific to the command
+	summary string
+	flags   func(o *options, fs *flag.FlagSet)
+	run     func(ctx context.Context, o *options) error
+
+	// changesDatabase makes the command ask for confirmation, or -yes, in an
+	// environment whose profile sets confirm
+	changesDatabase bool
+}
+
+var commands = []*command{
+	{
+		name:    "up",
+		args:    "-db URL [-dry-run] [-validate] [-allow-out-of-order]",
+		summary: "Apply all pending migrations",
+		flags: func(o *options, fs *flag.FlagSet) {
+			o.connectionFlags(fs)
+			o.migrateFlags(fs)
+			o.validateFlag(fs)
+			o.outOfOrderFlag(fs)
+			o.yesFlag(fs)
+		},
+		run:             runUp,
+		changesDatabase: true,
+	},
+	{
+		name:    "down",
+		args:    "-db URL [-steps N | -batch[=N]] [-dry-run]",
+		summary: "Roll back the most recently applied migrations",
+		flags: func(o *options, fs *flag.FlagSet) {
+			o.connectionFlags(fs)
+			o.migrateFlags(fs)
+			o.yesFlag(fs)
+			fs.IntVar(&o.steps, "steps", 1, "Number of migrations to roll back")
+			fs.Var(&o.batch, "batch", "Roll back the migrations of the last up, or of batch N with -batch=N")
+		},
+		run:             runDown,
+		changesDatabase: true,
+	},
+	{
+		name:    "redo",
+		args:    "-db URL [-steps N] [-dry-run]",
+		summary: "Roll back the most recently applied migrations and apply them again",
+		flags: func(o *options, fs *flag.FlagSet) {
+			o.connectionFlags(fs)
+			o.migrateFlags(fs)
+			o.yesFlag(fs)
+			fs.IntVar(&o.steps, "steps", 1, "Number of migrations to redo")
+		},
+		run:             runRedo,
+		changesDatabase: true,
+	},
+	{
+		name:    "goto",
+		args:    "-db URL -version N [-dry-run] [-validate] [-allow-out-of-order]",
+		summary: "Migrate up or down to a specific version",
+		flags: func(o *options, fs *flag.FlagSet) {
+			o.connectionFlags(fs)
+			o.migrateFlags(fs)
+			o.validateFlag(fs)
+			o.outOfOrderFlag(fs)
+			o.yesFlag(fs)
+			fs.Int64Var(&o.version, "version", -1, "Target migration version, 0 rolls back everything")
+		},
+		run:             runGoto,
+		changesDatabase: true,
+	},
+	{
+		name:    "force",
+		args:    "-db URL -version N",
+		summary: "Record a version as applied without running any SQL, clearing a dirty state",
+		flags: func(o *options, fs *flag.FlagSet) {
+			o.connectionFlags(fs)
+			o.yesFlag(fs)
+			fs.Int64Var(&o.version, "version", -1, "Version the database is actually at, 0 removes every record")
+		},
+		run:             runForce,
+		changesDatabase: true,
+	},
+	{
+		name:    "status",
+		args:    "-db URL",
+		summary: "Show which migrations are applied, pending, missing or dirty",
+		flags: func(o *options, fs *flag.FlagSet) {
+			o.connectionFlags(fs)
+		},
+		run: runStatus,
+	},
+	{
+		name:    "validate",
+		args:    "-db URL",
+		summary: "Check that applied migrations still match their files",
+		flags: func(o *options, fs *flag.FlagSet) {
+			o.connectionFlags(fs)
+		},
+		run: runValidate,
+	},
+	{
+		name:    "create",
+		args:    "-name NAME [-seq | -timestamp] [-dir DIR] [-db URL]",
+		summary: "Create empty up and down migration files",
+		flags: func(o *options, fs *flag.FlagSet) {
+			fs.StringVar(&o.db, "db", "", "Database connection URL, used to pick a template (optional)")
+			fs.StringVar(&o.dir, "dir", "migrations", "Migrations directory")
+			o.configFlag(fs)
+			o.outputFlag(fs)
+			fs.StringVar(&o.name, "name", "", "Migration name")
+			fs.BoolVar(&o.seq, "seq", false, "Number the migration one above the highest existing version (the default)")
+			fs.BoolVar(&o.timestamp, "timestamp", false, "Number the migration with the current UTC time as YYYYMMDDHHMMSS")
+		},
+		run: runCreate,
+	},
+}
+
+// findCommand returns the command called name, or nil
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// options holds the values of every flag; each command registers the ones it uses
+type options struct {
+	config           string
+	env              string
+	confirm          bool // set by the config file, not a flag
+	yes              bool
+	output           string
+	report           *report // set with -output=json
+	db               string
+	dir              string
+	table            string
+	verbose          bool
+	lockWait         time.Duration
+	dryRun           bool
+	validate         bool
+	allowOutOfOrder  bool
+	lockTimeout      time.Duration
+	statementTimeout time.Duration
+	steps            int
+	batch            batchFlag
+	version          int64
+	name             string
+	seq              bool
+	timestamp        bool
+}
+
+// connectionFlags registers the flags of every command working on a database
+func (o *options) connectionFlags(fs *flag.FlagSet) {
+	o.configFlag(fs)
+	o.outputFlag(fs)
+	fs.StringVar(&o.db, "db", "", "Database connection URL (format: dbtype://connection-url)")
+	fs.StringVar(&o.dir, "dir", "migrations", "Migrations directory")
+	fs.StringVar(&o.table, "table", migrations.DefaultTableName, "Table recording applied migrations")
+	fs.BoolVar(&o.verbose, "verbose", false, "Print debug output, such as each migration file loaded")
+	fs.DurationVar(&o.lockWait, "lock-wait", migrations.DefaultLockWaitTimeout, "How long to wait for another process holding the migration lock")
+}
+
+// configFlag registers -config and -env, read by applyConfig once the flags
+// are parsed
+func (o *options) configFlag(fs *flag.FlagSet) {
+	fs.StringVar(&o.config, "config", "", "Config file (default $MIGRATE_CONFIG, or migrate.yaml or migrate.toml if present)")
+	fs.StringVar(&o.env, "env", "", "Environment profile from the config file, such as staging (default $MIGRATE_ENV)")
+}
+
+// outputFlag registers -output, taken by every command
+func (o *options) outputFlag(fs *flag.FlagSet) {
+	fs.StringVar(&o.output, "output", outputText, "Output format, text or json")
+}
+
+// yesFlag registers -yes for the commands changing the database
+func (o *options) yesFlag(fs *flag.FlagSet) {
+	fs.BoolVar(&o.yes, "yes", false, "Do not ask for confirmation in an environment that requires it")
+}
+
+// migrateFlags registers the flags of the commands running migrations
+func (o *options) migrateFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&o.dryRun, "dry-run", false, "Print the SQL that would run without executing it")
+	fs.DurationVar(&o.lockTimeout, "lock-timeout", 0, "How long each migration statement may wait for table or row locks (0 keeps the database default)")
+	fs.DurationVar(&o.statementTimeout, "statement-timeout", 0, "How long each migration statement may run (0 keeps the database default)")
+}
+
+func (o *options) validateFlag(fs *flag.FlagSet) {
+	fs.BoolVar(&o.validate, "validate", false, "Refuse to migrate if applied migrations were edited, renamed or deleted")
+}
+
+func (o *options) outOfOrderFlag(fs *flag.FlagSet) {
+	fs.BoolVar(&o.allowOutOfOrder, "allow-out-of-order", false, "Apply pending migrations older than the newest applied one instead of failing")
+}
+
+// runRedo rolls back and re-applies migrations for "migrate redo"
+func runRedo(ctx context.Context, o *options) error {
+	migrator, closeDB, err := o.openMigrator(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	if o.dryRun {
+		plan, err := migrator.PlanRedoContext(ctx, o.steps)
+		if err != nil {
+			return err
+		}
+		o.printPlan(plan)
+		return nil
+	}
+
+	if err := migrator.RedoContext(ctx, o.steps); err != nil {
+		return err
+	}
+	o.printf("Redo of %d migration(s) completed successfully\n", o.steps)
+	return nil
+}
+
+// batchFlag is -batch, which alone selects the most recent batch and with
+// -batch=N batch N
+type batchFlag struct {
+	set bool
+	id  int64 // 0 for the most recent batch
+}
+
+func (f *batchFlag) String() string {
+	if f == nil || f.id == 0 {
+		return ""
+	}
+	return strconv.FormatInt(f.id, 10)
+}
+
+func (f *batchFlag) Set(value string) error {
+	if value == "true" {
+		f.set, f.id = true, 0
+		return nil
+	}
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || id < 1 {
+		return fmt.Errorf("expected a batch number of 1 or more, got %q", value)
+	}
+	f.set, f.id = true, id
+	return nil
+}
+
+// IsBoolFlag lets -batch be given without a value.
+func (f *batchFlag) IsBoolFlag() bool { return true }
+
+// openMigrator connects to the database and loads the migrations. The
+// returned function closes the connection.
+func (o *options) openMigrator(ctx context.Context) (*migrations.Migrator, func(), error) {
+	if o.db == "" {
+		return nil, nil, usageErrorf("-db is required, or set MIGRATE_DB or db in the config file")
+	}
+	dbConfig, err := ParseDBURL(o.db)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	db, err := initializeDB(dbConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	logLevel := slog.LevelInfo
+	if o.verbose {
+		logLevel = slog.LevelDebug
+	}
+	// A report keeps stdout for the JSON, so progress goes to stderr
+	logOutput := os.Stdout
+	var onMigration func(migrations.MigrationResult)
+	if o.report != nil {
+		logOutput = os.Stderr
+		onMigration = o.report.addMigration
+	}
+
+	migrator := migrations.New(db, o.dir, migrations.Config{
+		DatabaseType:      dbConfig.Type,
+		TableName:         o.table,
+		ValidateOnMigrate: o.validate,
+		AllowOutOfOrder:   o.allowOutOfOrder,
+		LockWaitTimeout:   o.lockWait,
+		LockTimeout:       o.lockTimeout,
+		StatementTimeout:  o.statementTimeout,
+		Logger:            slog.New(newConsoleHandler(logOutput, logLevel)),
+		OnMigration:       onMigration,
+	})
+
+	// A dry run only reads the migration state, so it must not create the table
+	if !o.dryRun {
+		if err := migrator.InitContext(ctx); err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+	}
+
+	if err := migrator.LoadMigrationsContext(ctx); err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+
+	return migrator, func() { db.Close() }, nil
+}
+
+func runUp(ctx context.Context, o *options) error {
+	migrator, closeDB, err := o.openMigrator(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	if o.dryRun {
+		plan, err := migrator.PlanContext(ctx)
+		if err != nil {
+			return err
+		}
+		o.printPlan(plan)
+		return nil
+	}
+
+	if err := migrator.MigrateContext(ctx); err != nil {
+		return err
+	}
+	o.printf("Migrations completed successfully\n")
+	return nil
+}
+
+func runDown(ctx context.Context, o *options) error {
+	if o.batch.set && o.steps != 1 {
+		return usageErrorf("-batch and -steps cannot be combined")
+	}
+	migrator, closeDB, err := o.openMigrator(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	if o.batch.set {
+		return runDownBatch(ctx, o, migrator)
+	}
+
+	if o.dryRun {
+		plan, err := migrator.PlanRollbackContext(ctx, o.steps)
+		if err != nil {
+			return err
+		}
+		o.printPlan(plan)
+		return nil
+	}
+
+	if err := migrator.RollbackContext(ctx, o.steps); err != nil {
+		return err
+	}
+	o.printf("Rollback of %d migration(s) completed successfully\n", o.steps)
+	return nil
+}
+
+// runDownBatch rolls back a batch for "migrate down -batch"
+func runDownBatch(ctx context.Context, o *options, migrator *migrations.Migrator) error {
+	if o.dryRun {
+		plan, err := migrator.PlanRollbackBatchContext(ctx, o.batch.id)
+		if err != nil {
+			return err
+		}
+		o.printPlan(plan)
+		return nil
+	}
+
+	if err := migrator.RollbackBatchContext(ctx, o.batch.id); err != nil {
+		return err
+	}
+	if o.batch.id == 0 {
+		o.printf("Rollback of the last batch completed successfully\n")
+	} else {
+		o.printf("Rollback of batch %d completed successfully\n", o.batch.id)
+	}
+	return nil
+}
+
+func runGoto(ctx context.Context, o *options) error {
+	if o.version < 0 {
+		return usageErrorf("-version is required")
+	}
+	migrator, closeDB, err := o.openMigrator(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	if o.dryRun {
+		plan, err := migrator.PlanMigrateToContext(ctx, o.version)
+		if err != nil {
+			return err
+		}
+		o.printPlan(plan)
+		return nil
+	}
+
+	if err := migrator.MigrateToContext(ctx, o.version); err != nil {
+		return err
+	}
+	o.printf("Migrated to version %d successfully\n", o.version)
+	return nil
+}
+
+func runForce(ctx context.Context, o *options) error {
+	if o.version < 0 {
+		return usageErrorf("-version is required")
+	}
+	migrator, closeDB, err := o.openMigrator(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	if err := migrator.ForceContext(ctx, o.version); err != nil {
+		return err
+	}
+	o.printf("Forced recorded version to %d\n", o.version)
+	return nil
+}
+
+func runStatus(ctx context.Context, o *options) error {
+	migrator, closeDB, err := o.openMigrator(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	statuses, err := migrator.StatusContext(ctx)
+	if err != nil {
+		return err
+	}
+	o.printStatus(statuses)
+	return nil
+}
+
+func runValidate(ctx context.Context, o *options) error {
+	migrator, closeDB, err := o.openMigrator(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	if err := migrator.ValidateContext(ctx); err != nil {
+		return err
+	}
+	o.printf("All applied migrations match their files\n")
+	return nil
+}
+
+func runCreate(ctx context.Context, o *options) error {
+	if o.name == "" {
+		return usageErrorf("-name is required")
+	}
+	if o.seq && o.timestamp {
+		return usageErrorf("-seq and -timestamp cannot be combined")
+	}
+
+	// The database only picks the template, so it is neither required nor opened
+	dbType := ""
+	if o.db != "" {
+		dbConfig, err := ParseDBURL(o.db)
+		if err != nil {
+			return err
+		}
+		dbType = dbConfig.Type
+	}
+
+	version, files, err := createMigrationFiles(o.dir, o.name, dbType, o.timestamp)
+	if err != nil {
+		return err
+	}
+	if o.report != nil {
+		o.report.Migrations = append(o.report.Migrations, migrationReport{Version: version, Name: o.name})
+		o.report.Files = files
+	}
+	o.printf("Created migration files for %s\n", o.name)
+	return nil
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultConfigFiles are looked for in the current directory when neither
+// -config nor MIGRATE_CONFIG names a config file
+var defaultConfigFiles = []string{"migrate.yaml", "migrate.yml", "migrate.toml"}
+
+// configurable lists the flags that can also be set in the config file and
+// with MIGRATE_* environment variables, such as MIGRATE_LOCK_TIMEOUT for
+// -lock-timeout. In the config file they are written lock_timeout.
+var configurable = []string{"db", "dir", "table", "lock-wait", "lock-timeout", "statement-timeout", "validate", "allow-out-of-order", "verbose", "output"}
+
+// confirmSetting is the safety setting making commands that change the
+// database ask for confirmation, usually set for a production environment
+const confirmSetting = "confirm"
+
+// configFile is a parsed migrate.yaml or migrate.toml. Its settings still
+// hold ${VAR} references, which applyConfig expands only for the top of the
+// file and the selected profile.
+type configFile struct {
+	path         string
+	settings     map[string]string            // by flag name
+	environments map[string]map[string]string // profiles selected with -env
+}
+
+// configLayer is a set of settings and where they came from
+type configLayer struct {
+	source   string
+	settings map[string]string
+}
+
+// applyConfig fills in the flags of fs that were not given on the command
+// line, first from MIGRATE_* environment variables, then from the profile
+// selected with -env or MIGRATE_ENV, and then from the top of the config
+// file named by -config or the default one. A flag beats the environment,
+// which beats the profile, which beats the rest of the file.
+func applyConfig(fs *flag.FlagSet, o *options) error {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	file, err := loadConfig(o.config)
+	if err != nil {
+		return err
+	}
+
+	if o.env == "" {
+		o.env = os.Getenv("MIGRATE_ENV")
+	}
+	var layers []configLayer
+	if o.env != "" {
+		if file == nil {
+			return fmt.Errorf("environment %q needs a config file defining it", o.env)
+		}
+		profile, ok := file.environments[o.env]
+		if !ok {
+			return fmt.Errorf("environment %q is not defined in %s", o.env, file.path)
+		}
+		if profile, err = expand(profile); err != nil {
+			return fmt.Errorf("config file %s: environment %q: %v", file.path, o.env, err)
+		}
+		layers = append(layers, configLayer{source: fmt.Sprintf("%s (environment %s)", file.path, o.env), settings: profile})
+	}
+	if file != nil {
+		settings, err := expand(file.settings)
+		if err != nil {
+			return fmt.Errorf("config file %s: %v", file.path, err)
+		}
+		layers = append(layers, configLayer{source: file.path, settings: settings})
+	}
+
+	lookup := func(name string) (value, source string, ok bool) {
+		if value, ok := os.LookupEnv(envName(name)); ok {
+			return value, envName(name), true
+		}
+		for _, layer := range layers {
+			if value, ok := layer.settings[name]; ok {
+				return value, layer.source, true
+			}
+		}
+		return "", "", false
+	}
+
+	for _, name := range configurable {
+		if set[name] || fs.Lookup(name) == nil {
+			continue
+		}
+		value, source, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("invalid %s in %s: %v", strings.ReplaceAll(name, "-", "_"), source, err)
+		}
+	}
+
+	if value, source, ok := lookup(confirmSetting); ok {
+		if o.confirm, err = strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("invalid %s in %s: %v", confirmSetting, source, err)
+		}
+	}
+	return nil
+}
+
+// envName returns the environment variable for the flag called name
+func envName(name string) string {
+	return "MIGRATE_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// loadConfig reads the config file at path. With an empty path it falls back
+// to MIGRATE_CONFIG and then to the default files, returning nil if there is
+// none.
+func loadConfig(path string) (*configFile, error) {
+	if path == "" {
+		path = os.Getenv("MIGRATE_CONFIG")
+	}
+	if path == "" {
+		for _, name := range defaultConfigFiles {
+			if _, err := os.Stat(name); err == nil {
+				path = name
+				break
+			} else if !errors.Is(err, fs.ErrNotExist) {
+				return nil, err
+			}
+		}
+		if path == "" {
+			return nil, nil
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	var values map[string]interface{}
+	switch ext := filepath.Ext(path); ext {
+	case ".yaml", ".yml":
+		values, err = parseYAML(data)
+	case ".toml":
+		values, err = parseTOML(data)
+	default:
+		return nil, fmt.Errorf("config file %s: unknown format %q, expected .yaml, .yml or .toml", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %v", path, err)
+	}
+
+	file := &configFile{path: path, environments: map[string]map[string]string{}}
+	if environments, ok := values["environments"]; ok {
+		profiles, ok := environments.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("config file %s: environments must be a table of profiles", path)
+		}
+		for name, profile := range profiles {
+			values, ok := profile.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("config file %s: environment %q must be a table of settings", path, name)
+			}
+			if file.environments[name], err = settings(values); err != nil {
+				return nil, fmt.Errorf("config file %s: environment %q: %v", path, name, err)
+			}
+		}
+		delete(values, "environments")
+	}
+	if file.settings, err = settings(values); err != nil {
+		return nil, fmt.Errorf("config file %s: %v", path, err)
+	}
+	return file, nil
+}
+
+// settings converts the values of a config file table to flag values by flag
+// name
+func settings(values map[string]interface{}) (map[string]string, error) {
+	result := map[string]string{}
+	for key, value := range values {
+		name := strings.ReplaceAll(key, "_", "-")
+		if !isConfigurable(name) && name != confirmSetting {
+			return nil, fmt.Errorf("unknown setting %q", key)
+		}
+		switch value.(type) {
+		case string, bool, int, int64, uint64, float64:
+			result[name] = fmt.Sprint(value)
+		case nil:
+			return nil, fmt.Errorf("setting %q has no value", key)
+		default:
+			return nil, fmt.Errorf("setting %q must be a string, number or boolean", key)
+		}
+	}
+	return result, nil
+}
+
+// expand returns settings with ${VAR} references replaced by the values of
+// environment variables
+func expand(settings map[string]string) (map[string]string, error) {
+	result := make(map[string]string, len(settings))
+	for name, value := range settings {
+		expanded, err := interpolate(value)
+		if err != nil {
+			return nil, fmt.Errorf("setting %q: %v", strings.ReplaceAll(name, "-", "_"), err)
+		}
+		result[name] = expanded
+	}
+	return result, nil
+}
+
+func isConfigurable(name string) bool {
+	for _, configurableName := range configurable {
+		if name == configurableName {
+			return true
+		}
+	}
+	return false
+}
+
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// interpolate replaces ${VAR} with the value of the environment variable VAR.
+// Unset variables are an error rather than silently empty.
+func interpolate(s string) (string, error) {
+	var missing []string
+	expanded := envReference.ReplaceAllStringFunc(s, func(ref string) string {
+		name := envReference.FindStringSubmatch(ref)[1]
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable %s is not set", strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
+
+// parseYAML decodes a YAML config file
+func parseYAML(data []byte) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// parseTOML decodes a TOML config file
+func parseTOML(data []byte) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	if err := toml.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
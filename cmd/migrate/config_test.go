@@ -0,0 +1,226 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestParseYAML verifies that migrate.yaml decodes to the settings and
+// profiles, including quoted URLs holding # and colons.
+func TestParseYAML(t *testing.T) {
+	data := `# migrate.yaml
+---
+db: "postgres://deploy:p#ss@db:5432/app?sslmode=disable"  # production
+dir: 'it''s here'
+lock_wait: 30s
+validate: true
+environments:
+  staging:
+    db: postgres://staging/app#not-a-comment
+    confirm: false
+  "prod":
+    confirm: true
+`
+	got, err := parseYAML([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"db":        "postgres://deploy:p#ss@db:5432/app?sslmode=disable",
+		"dir":       "it's here",
+		"lock_wait": "30s",
+		"validate":  true,
+		"environments": map[string]interface{}{
+			"staging": map[string]interface{}{
+				"db":      "postgres://staging/app#not-a-comment",
+				"confirm": false,
+			},
+			"prod": map[string]interface{}{"confirm": true},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseYAML:\ngot  %#v\nwant %#v", got, want)
+	}
+
+	for _, bad := range []string{
+		"db: x\ndb: y",
+		"db \"x\"",
+		"db: \"unterminated",
+		"environments:\n\tprod:\n",
+	} {
+		if _, err := parseYAML([]byte(bad)); err == nil {
+			t.Errorf("parseYAML(%q): expected an error", bad)
+		}
+	}
+}
+
+// TestParseTOML verifies that migrate.toml decodes to the settings and
+// profiles.
+func TestParseTOML(t *testing.T) {
+	data := `# migrate.toml
+db = "postgres://deploy:p#ss@db/app" # production
+dir = 'C:\migrations'
+verbose = true
+lock_wait = "1m"
+count = 1_000
+
+[environments.staging]
+db = "postgres://staging/app?x=\"y\""
+
+[environments."prod"]
+confirm = false
+`
+	got, err := parseTOML([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"db":        "postgres://deploy:p#ss@db/app",
+		"dir":       `C:\migrations`,
+		"verbose":   true,
+		"lock_wait": "1m",
+		"count":     int64(1000),
+		"environments": map[string]interface{}{
+			"staging": map[string]interface{}{"db": `postgres://staging/app?x="y"`},
+			"prod":    map[string]interface{}{"confirm": false},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseTOML:\ngot  %#v\nwant %#v", got, want)
+	}
+
+	for _, bad := range []string{
+		"db = 1\ndb = 2",
+		"db = unquoted",
+		"dir = 'it''s'",
+		"db",
+	} {
+		if _, err := parseTOML([]byte(bad)); err == nil {
+			t.Errorf("parseTOML(%q): expected an error", bad)
+		}
+	}
+}
+
+// TestLoadConfig verifies that values which are not a single string, number
+// or boolean are rejected, whichever format they are written in.
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		file, data, err string
+	}{
+		{"migrate.yaml", "dir:\n  - migrations", `setting "dir" must be a string, number or boolean`},
+		{"migrate.yaml", "db: {url: x}", `setting "db" must be a string, number or boolean`},
+		{"migrate.yaml", "db:", `setting "db" has no value`},
+		{"migrate.yaml", "environments:\n  prod: x", `environment "prod" must be a table of settings`},
+		{"migrate.yaml", "database: x", `unknown setting "database"`},
+		{"migrate.toml", `dir = ["a"]`, `setting "dir" must be a string, number or boolean`},
+		{"migrate.toml", "lock_wait = 07:30:00", `setting "lock_wait" must be a string, number or boolean`},
+		{"migrate.toml", "[[environments]]\ndb = \"x\"", "environments must be a table of profiles"},
+		{"migrate.json", "{}", `unknown format ".json"`},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), tt.file)
+		if err := os.WriteFile(path, []byte(tt.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadConfig(path); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("loadConfig(%q) = %v, want an error containing %q", tt.data, err, tt.err)
+		}
+	}
+}
+
+// TestApplyConfig verifies that a flag beats MIGRATE_* variables, which beat
+// the selected profile, which beats the top of the config file, and that only
+// the settings in use have their ${VAR} references expanded.
+func TestApplyConfig(t *testing.T) {
+	for _, name := range append(configurable, confirmSetting, "env", "config") {
+		unsetenv(t, envName(name))
+	}
+	unsetenv(t, "TEST_MIGRATE_DEV_DB")
+	t.Setenv("TEST_MIGRATE_PROD_DB", "prod.sqlite")
+	t.Setenv("MIGRATE_DIR", "from-env")
+
+	path := filepath.Join(t.TempDir(), "migrate.yaml")
+	config := `db: sqlite://top.sqlite
+dir: from-file
+table: from-file
+lock_wait: 45s
+environments:
+  prod:
+    db: sqlite://${TEST_MIGRATE_PROD_DB}
+    dir: from-profile
+    confirm: true
+  dev:
+    db: sqlite://${TEST_MIGRATE_DEV_DB}
+  lenient:
+    confirm: yes
+`
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	parse := func(args ...string) (*options, error) {
+		o := &options{}
+		fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+		o.connectionFlags(fs)
+		if err := fs.Parse(append([]string{"-config", path}, args...)); err != nil {
+			t.Fatal(err)
+		}
+		return o, applyConfig(fs, o)
+	}
+
+	o, err := parse("-env", "prod", "-table", "from-flag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.table != "from-flag" {
+		t.Errorf("Expected the flag to win, got table %q", o.table)
+	}
+	if o.dir != "from-env" {
+		t.Errorf("Expected MIGRATE_DIR to beat the profile, got dir %q", o.dir)
+	}
+	if o.db != "sqlite://prod.sqlite" {
+		t.Errorf("Expected the profile to beat the top of the file, got db %q", o.db)
+	}
+	if o.lockWait != 45*time.Second {
+		t.Errorf("Expected lock_wait from the top of the file, got %s", o.lockWait)
+	}
+	if !o.confirm {
+		t.Error("Expected confirm from the profile")
+	}
+
+	// Without a profile the top of the file applies
+	o, err = parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.db != "sqlite://top.sqlite" || o.confirm {
+		t.Errorf("Expected the top of the file without a profile, got db %q and confirm %v", o.db, o.confirm)
+	}
+
+	// Only the selected profile needs its variables set
+	if _, err := parse("-env", "dev"); err == nil || !strings.Contains(err.Error(), "TEST_MIGRATE_DEV_DB is not set") {
+		t.Errorf("Expected an error for the unset variable of the dev profile, got %v", err)
+	}
+	if _, err := parse("-env", "staging"); err == nil || !strings.Contains(err.Error(), `environment "staging" is not defined`) {
+		t.Errorf("Expected an error for an undefined profile, got %v", err)
+	}
+	// YAML 1.2 reads yes as a string, which is not a boolean
+	if _, err := parse("-env", "lenient"); err == nil || !strings.Contains(err.Error(), "invalid confirm") {
+		t.Errorf("Expected confirm: yes to be rejected, got %v", err)
+	}
+	t.Setenv("MIGRATE_LOCK_WAIT", "soon")
+	if _, err := parse(); err == nil || !strings.Contains(err.Error(), "invalid lock_wait in MIGRATE_LOCK_WAIT") {
+		t.Errorf("Expected an error naming MIGRATE_LOCK_WAIT, got %v", err)
+	}
+}
+
+// unsetenv unsets the environment variable name for the rest of the test
+func unsetenv(t *testing.T, name string) {
+	t.Setenv(name, "")
+	os.Unsetenv(name)
+}
@@ -7,8 +7,9 @@ import (
 )
 
 type DBConfig struct {
-	Type string
-	URL  string
+	Type   string // migrations dialect
+	Driver string // database/sql driver, if it differs from Type
+	URL    string
 }
 
 // ParseDBURL parses the database URL and returns the type and modified URL if needed
@@ -24,12 +25,18 @@ func ParseDBURL(dbURL string) (*DBConfig, error) {
 	switch dbType {
 	case "postgres", "postgresql":
 		return &DBConfig{Type: "postgres", URL: dbURL}, nil
+	case "cockroach", "cockroachdb":
+		// CockroachDB speaks the PostgreSQL protocol
+		return &DBConfig{Type: "cockroach", Driver: "postgres", URL: "postgres://" + connectionURL}, nil
 	case "mysql":
 		// MySQL driver doesn't expect mysql:// prefix
 		return &DBConfig{Type: "mysql", URL: connectionURL}, nil
 	case "sqlite", "sqlite3":
 		// SQLite driver expects just the file path
 		return &DBConfig{Type: "sqlite3", URL: connectionURL}, nil
+	case "sqlserver", "mssql":
+		// go-mssqldb only accepts the sqlserver:// scheme
+		return &DBConfig{Type: "sqlserver", URL: "sqlserver://" + connectionURL}, nil
 	default:
 		return nil, fmt.Errorf("unsupported database type: %s", dbType)
 	}
@@ -37,7 +44,11 @@ func ParseDBURL(dbURL string) (*DBConfig, error) {
 
 // initializeDB sets up the database connection and schema based on the database type
 func initializeDB(config *DBConfig) (*sql.DB, error) {
-	db, err := sql.Open(config.Type, config.URL)
+	driver := config.Driver
+	if driver == "" {
+		driver = config.Type
+	}
+	db, err := sql.Open(driver, config.URL)
 	if err != nil {
 		return nil, fmt.Errorf("error opening database: %v", err)
 	}
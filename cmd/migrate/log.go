@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// consoleHandler prints log records as "Message key=value ..." lines for a
+// person at a terminal, without timestamps or levels
+type consoleHandler struct {
+	mu    *sync.Mutex
+	w     io.Writer
+	level slog.Level
+	attrs []slog.Attr
+}
+
+func newConsoleHandler(w io.Writer, level slog.Level) *consoleHandler {
+	return &consoleHandler{mu: &sync.Mutex{}, w: w, level: level}
+}
+
+func (h *consoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *consoleHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	if r.Message != "" {
+		b.WriteString(strings.ToUpper(r.Message[:1]) + r.Message[1:])
+	}
+
+	write := func(a slog.Attr) bool {
+		value := a.Value.Resolve()
+		if value.Kind() == slog.KindDuration {
+			value = slog.StringValue(value.Duration().Round(time.Microsecond).String())
+		}
+		fmt.Fprintf(&b, " %s=%v", a.Key, value)
+		return true
+	}
+	for _, a := range h.attrs {
+		write(a)
+	}
+	r.Attrs(write)
+	b.WriteString("\n")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append(append([]slog.Attr{}, h.attrs...), attrs...)
+	return &clone
+}
+
+// WithGroup is not used by the migrations package; groups are flattened.
+func (h *consoleHandler) WithGroup(string) slog.Handler {
+	return h
+}
@@ -1,90 +1,365 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
+	_ "github.com/microsoft/go-mssqldb"
 	"github.com/surajsingh0/go-migrate-easy/migrations"
 )
 
+// Exit codes, one per class of failure
+const (
+	exitOK          = 0
+	exitError       = 1 // the command failed for another reason, such as an unreachable database
+	exitUsage       = 2 // the command line was invalid
+	exitMigration   = 3 // a migration failed to apply or roll back
+	exitDirty       = 4 // the database is dirty and needs force
+	exitDrift       = 5 // applied migrations no longer match their files
+	exitLocked      = 6 // timed out waiting for the migration lock
+	exitInterrupted = 7 // interrupted by a signal or timeout
+	exitOutOfOrder  = 8 // pending migrations are older than the newest applied one
+)
+
 func main() {
-	dbURL := flag.String("db", "", "Database connection URL (format: dbtype://connection-url)")
-	migrationsDir := flag.String("dir", "migrations", "Migrations directory")
-	command := flag.String("command", "up", "Command to run (up, down, create)")
-	name := flag.String("name", "", "Migration name (required for create)")
-	steps := flag.Int("steps", 1, "Number of migrations to rollback (only used with 'down' command)")
-	flag.Parse()
+	os.Exit(run(os.Args[1:]))
+}
 
-	if *dbURL == "" {
-		log.Fatal("Database URL is required")
+// run executes the command line and returns the exit code
+func run(args []string) int {
+	if len(args) == 0 {
+		printUsage(os.Stderr)
+		return exitUsage
 	}
 
-	dbConfig, err := ParseDBURL(*dbURL)
-	if err != nil {
-		log.Fatal(err)
+	name, args := args[0], args[1:]
+	switch {
+	case name == "help" || name == "-h" || name == "-help" || name == "--help":
+		if len(args) == 0 {
+			printUsage(os.Stdout)
+			return exitOK
+		}
+		cmd := findCommand(args[0])
+		if cmd == nil {
+			fmt.Fprintf(os.Stderr, "migrate: unknown command %q\n", args[0])
+			return exitUsage
+		}
+		fs := cmd.flagSet(&options{})
+		fs.SetOutput(os.Stdout)
+		fs.Usage()
+		return exitOK
+	case strings.HasPrefix(name, "-"):
+		return runLegacy(append([]string{name}, args...))
 	}
 
-	db, err := initializeDB(dbConfig)
-	if err != nil {
-		log.Fatal(err)
+	cmd := findCommand(name)
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "migrate: unknown command %q\n\n", name)
+		printUsage(os.Stderr)
+		return exitUsage
+	}
+
+	o := &options{}
+	fs := cmd.flagSet(o)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "migrate %s: unexpected argument %q\n", cmd.name, fs.Arg(0))
+		fs.Usage()
+		return exitUsage
+	}
+	if err := applyConfig(fs, o); err != nil {
+		fmt.Fprintf(os.Stderr, "migrate: %v\n", err)
+		return exitUsage
+	}
+	return execute(cmd, o)
+}
+
+// runLegacy runs a command line of earlier releases, which chose the command
+// with -command and accepted every flag whatever the command.
+//
+// Deprecated: kept for existing scripts; use "migrate <command>" instead.
+func runLegacy(args []string) int {
+	o := &options{}
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	name := fs.String("command", "up", "Command to run (deprecated, use migrate <command>)")
+	o.connectionFlags(fs)
+	o.migrateFlags(fs)
+	o.validateFlag(fs)
+	o.outOfOrderFlag(fs)
+	o.yesFlag(fs)
+	fs.IntVar(&o.steps, "steps", 1, "Number of migrations to roll back")
+	fs.Int64Var(&o.version, "version", -1, "Target migration version")
+	fs.StringVar(&o.name, "name", "", "Migration name")
+	fs.Usage = func() { printUsage(fs.Output()) }
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if err := applyConfig(fs, o); err != nil {
+		fmt.Fprintf(os.Stderr, "migrate: %v\n", err)
+		return exitUsage
 	}
-	defer db.Close()
 
-	migrator := migrations.New(db, *migrationsDir, migrations.Config{
-		DatabaseType: dbConfig.Type,
-		// Add any database-specific configuration here
-	})
+	cmd := findCommand(*name)
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "migrate: unknown command %q\n", *name)
+		return exitUsage
+	}
+	fmt.Fprintf(os.Stderr, "migrate: -command is deprecated, use \"migrate %s\" instead\n", cmd.name)
 
-	if err := migrator.Init(); err != nil {
-		log.Fatal(err)
+	// Never let a dry run fall through to a command that would change the database
+	if o.dryRun && cmd.flagSet(&options{}).Lookup("dry-run") == nil {
+		fmt.Fprintf(os.Stderr, "migrate: -dry-run is not supported for %s command\n", cmd.name)
+		return exitUsage
 	}
+	return execute(cmd, o)
+}
+
+// execute runs cmd with its flags parsed into o, canceling it cleanly on
+// Ctrl-C or when the job is terminated. With -output=json the result, failed
+// or not, is printed as a report.
+func execute(cmd *command, o *options) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	if err := migrator.LoadMigrations(); err != nil {
-		log.Fatal(err)
+	var err error
+	switch o.output {
+	case outputText:
+	case outputJSON:
+		o.report = newReport(cmd.name)
+	default:
+		err = usageErrorf("-output must be %s or %s, not %q", outputText, outputJSON, o.output)
+	}
+	if err == nil {
+		err = confirm(cmd, o)
+	}
+	if err == nil {
+		err = cmd.run(ctx, o)
 	}
 
-	switch *command {
-	case "up":
-		if err := migrator.Migrate(); err != nil {
-			log.Fatal(err)
+	code, _ := exitCode(err)
+	if o.report != nil {
+		o.report.OK = err == nil
+		if err != nil {
+			o.report.fail(err)
 		}
-		fmt.Println("Migrations completed successfully")
+		o.report.write()
+	}
+	switch {
+	case err == nil:
+	case code == exitUsage:
+		fmt.Fprintf(os.Stderr, "migrate %s: %v\n", cmd.name, err)
+		cmd.flagSet(&options{}).Usage()
+	case o.report == nil:
+		log.Print(err)
+	}
+	return code
+}
+
+// confirm asks before a command changes the database of an environment
+// whose profile sets confirm, unless -yes was given. Without a terminal to
+// ask on, -yes is required.
+func confirm(cmd *command, o *options) error {
+	if !cmd.changesDatabase || !o.confirm || o.dryRun || o.yes {
+		return nil
+	}
+
+	target := "the database"
+	if o.env != "" {
+		target = fmt.Sprintf("environment %q", o.env)
+	}
+	if !stdinIsTerminal() {
+		return usageErrorf("%s requires confirmation; pass -yes to run %s non-interactively", target, cmd.name)
+	}
+
+	fmt.Fprintf(os.Stderr, "Run %s against %s? [y/N] ", cmd.name, target)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	default:
+		return errors.New("aborted")
+	}
+}
+
+// stdinIsTerminal reports whether standard input is a terminal that confirm
+// can ask on; tests replace it
+var stdinIsTerminal = func() bool {
+	stat, err := os.Stdin.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
+
+// flagSet returns the flags of cmd, parsed into o
+func (cmd *command) flagSet(o *options) *flag.FlagSet {
+	fs := flag.NewFlagSet("migrate "+cmd.name, flag.ContinueOnError)
+	cmd.flags(o, fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: migrate %s %s\n\n%s.\n\nOptions:\n", cmd.name, cmd.args, cmd.summary)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// printUsage writes the list of commands
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: migrate <command> [options]\n\nCommands:\n")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", cmd.name, cmd.summary)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\nRun \"migrate help <command>\" for the options of a command.\n")
+}
 
-	case "down":
-		if err := migrator.Rollback(*steps); err != nil {
-			log.Fatal(err)
+// usageError is an invalid command line found after parsing the flags
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string { return e.msg }
+
+func usageErrorf(format string, args ...interface{}) error {
+	return &usageError{msg: fmt.Sprintf(format, args...)}
+}
+
+// printPlan writes the statements of a dry run to stdout as a SQL script, or
+// adds them to the report
+func (o *options) printPlan(plan []migrations.PlanStep) {
+	if o.report != nil {
+		o.report.DryRun = true
+		for _, step := range plan {
+			o.report.Migrations = append(o.report.Migrations, migrationReport{
+				Version:    step.Version,
+				Name:       step.Name,
+				Direction:  step.Direction,
+				Statements: step.Statements,
+			})
+		}
+		return
+	}
+	if len(plan) == 0 {
+		fmt.Println("-- Nothing to do")
+		return
+	}
+	for i, step := range plan {
+		if i > 0 {
+			fmt.Println()
 		}
-		fmt.Printf("Rollback of %d migration(s) completed successfully\n", *steps)
+		fmt.Printf("-- Migration %d: %s (%s)\n", step.Version, step.Name, step.Direction)
+		for _, statement := range step.Statements {
+			if !strings.HasPrefix(statement, "--") && !strings.HasSuffix(statement, ";") {
+				statement += ";"
+			}
+			fmt.Println(statement)
+		}
+	}
+}
 
-	case "create":
-		if *name == "" {
-			log.Fatal("Migration name is required for create command")
+// printStatus writes the migration status report as a table to stdout, or
+// adds it to the report
+func (o *options) printStatus(statuses []migrations.MigrationStatus) {
+	if o.report != nil {
+		for _, status := range statuses {
+			o.report.Migrations = append(o.report.Migrations, migrationReport{
+				Version:    status.Version,
+				Name:       status.Name,
+				State:      string(status.State),
+				AppliedAt:  status.AppliedAt,
+				OutOfOrder: status.OutOfOrder,
+				Batch:      status.Batch,
+			})
+		}
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATE\tBATCH\tAPPLIED AT")
+	for _, status := range statuses {
+		appliedAt := "-"
+		if status.AppliedAt != nil {
+			appliedAt = status.AppliedAt.Local().Format("2006-01-02 15:04:05")
 		}
-		if err := createMigrationFiles(*migrationsDir, *name, dbConfig.Type); err != nil {
-			log.Fatal(err)
+		batch := "-"
+		if status.Batch > 0 {
+			batch = strconv.FormatInt(status.Batch, 10)
 		}
-		fmt.Printf("Created migration files for %s\n", *name)
+		state := string(status.State)
+		if status.OutOfOrder {
+			state += " (out of order)"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", status.Version, status.Name, state, batch, appliedAt)
+	}
+	w.Flush()
+}
 
-	default:
-		log.Fatalf("Unknown command: %s", *command)
+// printf writes a message for a person to stdout; reports leave it out
+func (o *options) printf(format string, args ...interface{}) {
+	if o.report == nil {
+		fmt.Printf(format, args...)
 	}
 }
 
-func createMigrationFiles(dir, name, dbType string) error {
+// now returns the current time; tests replace it to create migrations within
+// a given second
+var now = time.Now
+
+// createMigrationFiles creates the up and down files of a new migration and
+// returns its version and their paths. The version is one above the highest
+// existing one, or the current UTC time as YYYYMMDDHHMMSS with timestamp, so
+// that migrations created on different branches rarely collide.
+func createMigrationFiles(dir, name, dbType string, timestamp bool) (int64, []string, error) {
 	if err := os.MkdirAll(dir, 0755); err != nil {
-		return err
+		return 0, nil, err
 	}
 
 	files, err := os.ReadDir(dir)
 	if err != nil {
-		return err
+		return 0, nil, err
+	}
+
+	// Files that are not migrations, such as a README, are ignored
+	existing := map[int64]bool{}
+	var highest int64
+	for _, file := range files {
+		version, _, _, err := migrations.ParseFilename(file.Name())
+		if err != nil || file.IsDir() {
+			continue
+		}
+		existing[version] = true
+		if version > highest {
+			highest = version
+		}
+	}
+
+	version := highest + 1
+	if timestamp {
+		version, _ = strconv.ParseInt(now().UTC().Format("20060102150405"), 10, 64)
+		// Two migrations created within the same second get consecutive versions
+		for existing[version] {
+			version++
+		}
 	}
-	version := len(files)/2 + 1
 
 	// Template for different database types
 	var upTemplate, downTemplate string
@@ -92,12 +367,18 @@ func createMigrationFiles(dir, name, dbType string) error {
 	case "postgres":
 		upTemplate = "-- PostgreSQL up migration\n"
 		downTemplate = "-- PostgreSQL down migration\n"
+	case "cockroach":
+		upTemplate = "-- CockroachDB up migration\n"
+		downTemplate = "-- CockroachDB down migration\n"
 	case "mysql":
 		upTemplate = "-- MySQL up migration\n"
 		downTemplate = "-- MySQL down migration\n"
 	case "sqlite3":
 		upTemplate = "-- SQLite up migration\n"
 		downTemplate = "-- SQLite down migration\n"
+	case "sqlserver":
+		upTemplate = "-- SQL Server up migration\n"
+		downTemplate = "-- SQL Server down migration\n"
 	default:
 		upTemplate = "-- Add migration up SQL here\n"
 		downTemplate = "-- Add migration down SQL here\n"
@@ -106,14 +387,14 @@ func createMigrationFiles(dir, name, dbType string) error {
 	// Create up migration
 	upFile := fmt.Sprintf("%s/%03d_%s_up.sql", dir, version, name)
 	if err := os.WriteFile(upFile, []byte(upTemplate), 0644); err != nil {
-		return err
+		return 0, nil, err
 	}
 
 	// Create down migration
 	downFile := fmt.Sprintf("%s/%03d_%s_down.sql", dir, version, name)
 	if err := os.WriteFile(downFile, []byte(downTemplate), 0644); err != nil {
-		return err
+		return 0, nil, err
 	}
 
-	return nil
+	return version, []string{upFile, downFile}, nil
 }
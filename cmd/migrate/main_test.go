@@ -0,0 +1,166 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestCreateMigrationFiles verifies that a new migration is numbered one above
+// the highest existing version, ignoring files that are not migrations.
+func TestCreateMigrationFiles(t *testing.T) {
+	dir := t.TempDir()
+	// Version 3 is missing and version 5 has no down file
+	for _, name := range []string{
+		"README.md",
+		"notes.sql",
+		"001_create_users_up.sql",
+		"001_create_users_down.sql",
+		"002_add_email_up.sql",
+		"002_add_email_down.sql",
+		"004_create_orders_up.sql",
+		"004_create_orders_down.sql",
+		"005_orphan_up.sql",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	version, files, err := createMigrationFiles(dir, "add_index", "sqlite3", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 6 {
+		t.Errorf("Expected version 6, got %d", version)
+	}
+	want := []string{dir + "/006_add_index_up.sql", dir + "/006_add_index_down.sql"}
+	for i, file := range want {
+		if i >= len(files) || files[i] != file {
+			t.Errorf("Expected files %v, got %v", want, files)
+			break
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) == 0 {
+			t.Errorf("Expected a template in %s", file)
+		}
+	}
+}
+
+// TestCreateMigrationFilesTimestamp verifies that migrations created within
+// the same second get consecutive timestamp versions.
+func TestCreateMigrationFilesTimestamp(t *testing.T) {
+	clock := time.Date(2026, 3, 14, 15, 9, 26, 0, time.UTC)
+	now = func() time.Time { return clock }
+	t.Cleanup(func() { now = time.Now })
+
+	dir := t.TempDir()
+	for i, want := range []int64{20260314150926, 20260314150927, 20260314150928} {
+		version, _, err := createMigrationFiles(dir, "step", "", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version != want {
+			t.Errorf("Migration %d: expected version %d, got %d", i+1, want, version)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "20260314150928_step_up.sql")); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestCreateSeqAndTimestamp verifies that create refuses -seq with -timestamp
+// as a usage error and creates no files.
+func TestCreateSeqAndTimestamp(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "migrations")
+	if code := run([]string{"create", "-name", "x", "-seq", "-timestamp", "-dir", dir}); code != exitUsage {
+		t.Errorf("Expected exit code %d, got %d", exitUsage, code)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("Expected no migrations directory, got %v", err)
+	}
+}
+
+// TestRun verifies the exit code of run for command lines that are handled
+// before or instead of a command, and for the legacy -command form.
+func TestRun(t *testing.T) {
+	for _, name := range append(configurable, confirmSetting, "env", "config") {
+		unsetenv(t, envName(name))
+	}
+	dir := t.TempDir()
+	db := "sqlite://" + filepath.Join(dir, "test.sqlite")
+	migrationsDir := filepath.Join(dir, "migrations")
+	if err := os.Mkdir(migrationsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{"no arguments", nil, exitUsage},
+		{"unknown command", []string{"frobnicate"}, exitUsage},
+		{"stray argument", []string{"status", "-db", db, "extra"}, exitUsage},
+		{"unknown flag", []string{"status", "-steps", "1"}, exitUsage},
+		{"help", []string{"help"}, exitOK},
+		{"help command", []string{"help", "up"}, exitOK},
+		{"help unknown command", []string{"help", "frobnicate"}, exitUsage},
+		{"-h", []string{"-h"}, exitOK},
+		{"command -h", []string{"status", "-h"}, exitOK},
+		{"status", []string{"status", "-db", db, "-dir", migrationsDir}, exitOK},
+		{"legacy status", []string{"-command=status", "-db", db, "-dir", migrationsDir}, exitOK},
+		{"legacy unknown command", []string{"-command=frobnicate"}, exitUsage},
+		{"legacy create dry run", []string{"-command=create", "-name", "x", "-dry-run", "-dir", migrationsDir}, exitUsage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := run(tt.args); code != tt.code {
+				t.Errorf("run(%q) = %d, want %d", tt.args, code, tt.code)
+			}
+		})
+	}
+
+	// The refused dry run must not have created the migration
+	files, err := os.ReadDir(migrationsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("Expected the legacy dry run to create nothing, got %d files", len(files))
+	}
+}
+
+// TestConfirm verifies that a profile with confirm needs -yes when there is
+// no terminal to ask on, and that -yes and dry runs skip the question.
+func TestConfirm(t *testing.T) {
+	isTerminal := stdinIsTerminal
+	stdinIsTerminal = func() bool { return false }
+	t.Cleanup(func() { stdinIsTerminal = isTerminal })
+
+	up, status := findCommand("up"), findCommand("status")
+	tests := []struct {
+		name  string
+		cmd   *command
+		o     options
+		class string
+	}{
+		{"not confirmed", up, options{confirm: true, env: "prod"}, "usage"},
+		{"yes", up, options{confirm: true, yes: true}, ""},
+		{"dry run", up, options{confirm: true, dryRun: true}, ""},
+		{"confirm unset", up, options{}, ""},
+		{"read only command", status, options{confirm: true}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := confirm(tt.cmd, &tt.o)
+			if _, class := exitCode(err); class != tt.class {
+				t.Errorf("confirm() = %v, want class %q", err, tt.class)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/surajsingh0/go-migrate-easy/migrations"
+)
+
+// Output formats selected with -output
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// report is the result of a command printed to stdout with -output=json, as
+// a single JSON object whatever the command and whether it failed
+type report struct {
+	Command    string            `json:"command"`
+	OK         bool              `json:"ok"`
+	DryRun     bool              `json:"dry_run,omitempty"`
+	Migrations []migrationReport `json:"migrations"`
+	Files      []string          `json:"files,omitempty"`
+	Error      *errorReport      `json:"error,omitempty"`
+}
+
+// migrationReport is a migration applied, rolled back, planned, listed by
+// status, drifted or created, with the fields that apply
+type migrationReport struct {
+	Version    int64      `json:"version"`
+	Name       string     `json:"name"`
+	Direction  string     `json:"direction,omitempty"`
+	DurationMS float64    `json:"duration_ms,omitempty"`
+	State      string     `json:"state,omitempty"`
+	AppliedAt  *time.Time `json:"applied_at,omitempty"`
+	OutOfOrder bool       `json:"out_of_order,omitempty"`
+	Batch      int64      `json:"batch,omitempty"`
+	Statements []string   `json:"statements,omitempty"`
+	Drift      string     `json:"drift,omitempty"`
+	Detail     string     `json:"detail,omitempty"`
+}
+
+// errorReport describes why a command failed. Class is one of the failure
+// classes of exitCode; Version and Name are set when a migration failed.
+type errorReport struct {
+	Message  string `json:"message"`
+	Class    string `json:"class"`
+	ExitCode int    `json:"exit_code"`
+	Version  int64  `json:"version,omitempty"`
+	Name     string `json:"name,omitempty"`
+}
+
+func newReport(command string) *report {
+	return &report{Command: command, Migrations: []migrationReport{}}
+}
+
+// fail records err in the report
+func (r *report) fail(err error) {
+	code, class := exitCode(err)
+	r.Error = &errorReport{Message: err.Error(), Class: class, ExitCode: code}
+
+	var migrationErr *migrations.MigrationError
+	var dirtyErr *migrations.DirtyError
+	var driftErr *migrations.DriftError
+	var orderErr *migrations.OutOfOrderError
+	switch {
+	case errors.As(err, &migrationErr):
+		r.Error.Version, r.Error.Name = migrationErr.Version, migrationErr.Name
+	case errors.As(err, &dirtyErr):
+		r.Error.Version, r.Error.Name = dirtyErr.Version, dirtyErr.Name
+	case errors.As(err, &driftErr):
+		for _, drift := range driftErr.Drifts {
+			r.Migrations = append(r.Migrations, migrationReport{
+				Version: drift.Version,
+				Name:    drift.Name,
+				Drift:   string(drift.Kind),
+				Detail:  drift.Detail,
+			})
+		}
+	case errors.As(err, &orderErr):
+		for _, migration := range orderErr.Migrations {
+			r.Migrations = append(r.Migrations, migrationReport{
+				Version:    migration.Version,
+				Name:       migration.Name,
+				State:      string(migrations.StatePending),
+				OutOfOrder: true,
+			})
+		}
+	}
+}
+
+// addMigration adds a migration applied or rolled back to the report
+func (r *report) addMigration(result migrations.MigrationResult) {
+	r.Migrations = append(r.Migrations, migrationReport{
+		Version:    result.Version,
+		Name:       result.Name,
+		Direction:  result.Direction,
+		DurationMS: float64(result.Duration.Microseconds()) / 1000,
+	})
+}
+
+// write prints the report to stdout
+func (r *report) write() {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(r)
+}
+
+// exitCode returns the exit code and failure class of err, so scripts can
+// tell a broken migration from a busy lock without parsing messages
+func exitCode(err error) (int, string) {
+	var usageErr *usageError
+	var dirtyErr *migrations.DirtyError
+	var driftErr *migrations.DriftError
+	var migrationErr *migrations.MigrationError
+	var orderErr *migrations.OutOfOrderError
+	switch {
+	case err == nil:
+		return exitOK, ""
+	case errors.As(err, &usageErr):
+		return exitUsage, "usage"
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return exitInterrupted, "interrupted"
+	case errors.Is(err, migrations.ErrLockTimeout):
+		return exitLocked, "lock_timeout"
+	case errors.As(err, &dirtyErr):
+		return exitDirty, "dirty"
+	case errors.As(err, &driftErr):
+		return exitDrift, "drift"
+	case errors.As(err, &orderErr):
+		return exitOutOfOrder, "out_of_order"
+	case errors.As(err, &migrationErr):
+		return exitMigration, "migration"
+	default:
+		return exitError, "error"
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/surajsingh0/go-migrate-easy/migrations"
+)
+
+// TestExitCode verifies the exit code and class of each kind of failure,
+// including wrapped errors.
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		code  int
+		class string
+	}{
+		{"success", nil, exitOK, ""},
+		{"usage", usageErrorf("-batch and -steps cannot be combined"), exitUsage, "usage"},
+		{"canceled", fmt.Errorf("migration 2 interrupted: %w", context.Canceled), exitInterrupted, "interrupted"},
+		{"deadline", context.DeadlineExceeded, exitInterrupted, "interrupted"},
+		{"lock timeout", fmt.Errorf("%w after 15s", migrations.ErrLockTimeout), exitLocked, "lock_timeout"},
+		{"dirty", &migrations.DirtyError{Version: 3}, exitDirty, "dirty"},
+		{"drift", &migrations.DriftError{}, exitDrift, "drift"},
+		{"out of order", &migrations.OutOfOrderError{Latest: 8}, exitOutOfOrder, "out_of_order"},
+		{"migration", fmt.Errorf("migrate: %w", &migrations.MigrationError{Version: 2, Err: errors.New("syntax error")}), exitMigration, "migration"},
+		{"other", errors.New("connection refused"), exitError, "error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, class := exitCode(tt.err)
+			if code != tt.code || class != tt.class {
+				t.Errorf("exitCode(%v) = %d, %q, want %d, %q", tt.err, code, class, tt.code, tt.class)
+			}
+		})
+	}
+}
+
+// TestReport verifies the JSON shape of a report, with the migrations passed
+// to Config.OnMigration and the failure that stopped the command.
+func TestReport(t *testing.T) {
+	r := newReport("up")
+	r.addMigration(migrations.MigrationResult{Version: 1, Name: "create_users", Direction: "up", Duration: 1500 * time.Microsecond})
+	r.fail(&migrations.MigrationError{Version: 2, Name: "add_email", Err: errors.New("failed to apply migration 2: syntax error")})
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"command": "up",
+		"ok":      false,
+		"migrations": []interface{}{
+			map[string]interface{}{"version": 1.0, "name": "create_users", "direction": "up", "duration_ms": 1.5},
+		},
+		"error": map[string]interface{}{
+			"message":   "failed to apply migration 2: syntax error",
+			"class":     "migration",
+			"exit_code": 3.0,
+			"version":   2.0,
+			"name":      "add_email",
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected report:\n%s", data)
+	}
+
+	// A report with nothing to list still has an empty migrations array
+	data, err = json.Marshal(newReport("status"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"command":"status","ok":false,"migrations":[]}` {
+		t.Errorf("Unexpected empty report: %s", data)
+	}
+}
@@ -0,0 +1,88 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// RollbackBatch reverts every migration applied by one call to Migrate,
+// MigrateTo or Force, most recently applied first, in a single transaction
+// where the dialect allows. Batches are numbered from 1 in the order they
+// were applied; a batch of 0 means the most recent one, undoing the last
+// deploy.
+func (m *Migrator) RollbackBatch(batch int64) error {
+	return m.RollbackBatchContext(context.Background(), batch)
+}
+
+// RollbackBatchContext is like RollbackBatch but binds its transactions to ctx.
+func (m *Migrator) RollbackBatchContext(ctx context.Context, batch int64) error {
+	dialect, err := getDialect(m.config.DatabaseType)
+	if err != nil {
+		return err
+	}
+
+	return m.withLock(ctx, dialect, func() error {
+		return m.rollbackBatch(ctx, dialect, batch)
+	})
+}
+
+// rollbackBatch reverts a batch; the caller holds the migration lock
+func (m *Migrator) rollbackBatch(ctx context.Context, dialect Dialect, batch int64) error {
+	if err := m.checkDirty(ctx); err != nil {
+		return err
+	}
+
+	records, err := m.appliedRecords(ctx)
+	if err != nil {
+		return err
+	}
+
+	migrationsToRollback, err := m.batchMigrations(records, batch)
+	if err != nil {
+		return err
+	}
+
+	return m.revertMigrations(ctx, dialect, migrationsToRollback)
+}
+
+// batchMigrations resolves the migrations of batch, or of the most recent
+// batch if it is 0, most recently applied first
+func (m *Migrator) batchMigrations(records []appliedRecord, batch int64) ([]*Migration, error) {
+	if len(records) == 0 {
+		return nil, errors.New("no migrations to rollback")
+	}
+	if batch == 0 {
+		batch = lastBatch(records)
+		if batch == 0 {
+			return nil, errors.New("no batches recorded; migrations applied by earlier releases must be rolled back by steps")
+		}
+	}
+
+	var migrations []*Migration
+	for _, record := range newestFirst(records) {
+		if record.batch != batch {
+			continue
+		}
+		migration := m.findMigration(record.version)
+		if migration == nil {
+			return nil, fmt.Errorf("migration %d of batch %d is applied but was not loaded, cannot roll it back", record.version, batch)
+		}
+		migrations = append(migrations, migration)
+	}
+	if len(migrations) == 0 {
+		return nil, fmt.Errorf("no applied migrations in batch %d", batch)
+	}
+	return migrations, nil
+}
+
+// lastBatch returns the most recent batch in records, or 0 if there is none
+func lastBatch(records []appliedRecord) int64 {
+	var last int64
+	for _, record := range records {
+		if record.batch > last {
+			last = record.batch
+		}
+	}
+	return last
+}
@@ -0,0 +1,222 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Dialect encapsulates database-specific behaviors. The package ships with
+// dialects for "postgres", "cockroach", "mysql", "sqlite3" and "sqlserver";
+// other databases can be supported by implementing Dialect and calling
+// RegisterDialect.
+//
+// Table names passed to a Dialect are already quoted with QuoteIdentifier.
+type Dialect interface {
+	// CreateHistoryTableSQL returns a statement creating the history table,
+	// with a 64-bit version and name, applied_at, checksum, dirty,
+	// out_of_order and 64-bit sequence and batch columns, if it does not
+	// exist yet.
+	CreateHistoryTableSQL(table string) string
+
+	// AddColumnSQL returns a statement adding a column to the history table.
+	// Init uses it to upgrade tables created by earlier releases.
+	AddColumnSQL(table, column, definition string) string
+
+	// Placeholder returns the bind parameter for the n-th argument of a
+	// statement, starting at 1.
+	Placeholder(n int) string
+
+	// QuoteIdentifier quotes a table or column name. Dotted names such as
+	// schema.table are quoted part by part.
+	QuoteIdentifier(name string) string
+
+	// TransactionalDDL reports whether schema changes are rolled back with the
+	// surrounding transaction. Without it, each migration runs in a transaction
+	// of its own, apart from the bookkeeping against the history table, and is
+	// recorded as dirty until it has committed.
+	TransactionalDDL() bool
+
+	// Lock acquires a database-wide lock named name, waiting at most timeout,
+	// and returns a function releasing it. The error must wrap ErrLockTimeout
+	// if the lock was still held by someone else when timeout expired.
+	Lock(ctx context.Context, db *sql.DB, name string, timeout time.Duration) (unlock func() error, err error)
+}
+
+var (
+	dialectsMu sync.RWMutex
+	dialects   = map[string]Dialect{
+		"postgres":  postgresDialect{},
+		"cockroach": cockroachDialect{},
+		"mysql":     mysqlDialect{},
+		"sqlite3":   sqliteDialect{},
+		"sqlserver": sqlserverDialect{},
+	}
+)
+
+// RegisterDialect makes a dialect available under name, the value used for
+// Config.DatabaseType. Like sql.Register, it panics if dialect is nil or name
+// is already registered.
+func RegisterDialect(name string, dialect Dialect) {
+	dialectsMu.Lock()
+	defer dialectsMu.Unlock()
+	if dialect == nil {
+		panic("migrations: RegisterDialect dialect is nil")
+	}
+	if _, dup := dialects[name]; dup {
+		panic("migrations: RegisterDialect called twice for dialect " + name)
+	}
+	dialects[name] = dialect
+}
+
+// getDialect returns the appropriate dialect for the database type
+func getDialect(dbType string) (Dialect, error) {
+	dialectsMu.RLock()
+	defer dialectsMu.RUnlock()
+	dialect, ok := dialects[dbType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported database type: %s", dbType)
+	}
+	return dialect, nil
+}
+
+// quoteIdentifier quotes each dot-separated part of name with quote,
+// doubling any quote characters inside it
+func quoteIdentifier(name string, quote string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = quote + strings.ReplaceAll(part, quote, quote+quote) + quote
+	}
+	return strings.Join(parts, ".")
+}
+
+// addColumnSQL is the standard ALTER TABLE ... ADD COLUMN statement
+func addColumnSQL(table, column, definition string) string {
+	return fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) CreateHistoryTableSQL(table string) string {
+	return fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			version BIGINT PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+			checksum VARCHAR(64),
+			dirty INTEGER NOT NULL DEFAULT 0,
+			out_of_order INTEGER NOT NULL DEFAULT 0,
+			sequence BIGINT NOT NULL DEFAULT 0,
+			batch BIGINT NOT NULL DEFAULT 0
+		)`, table)
+}
+
+func (postgresDialect) AddColumnSQL(table, column, definition string) string {
+	return addColumnSQL(table, column, definition)
+}
+
+func (postgresDialect) Placeholder(n int) string { return fmt.Sprintf("$%d", n) }
+
+func (postgresDialect) QuoteIdentifier(name string) string { return quoteIdentifier(name, `"`) }
+
+func (postgresDialect) TransactionalDDL() bool { return true }
+
+// cockroachDialect shares PostgreSQL's SQL but not its advisory locks, and
+// runs schema changes outside the bookkeeping transaction
+type cockroachDialect struct {
+	postgresDialect
+}
+
+// TransactionalDDL is false because CockroachDB runs schema changes
+// asynchronously and handles them poorly alongside writes in one transaction.
+func (cockroachDialect) TransactionalDDL() bool { return false }
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) CreateHistoryTableSQL(table string) string {
+	return fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			version BIGINT PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			checksum VARCHAR(64),
+			dirty INTEGER NOT NULL DEFAULT 0,
+			out_of_order INTEGER NOT NULL DEFAULT 0,
+			sequence BIGINT NOT NULL DEFAULT 0,
+			batch BIGINT NOT NULL DEFAULT 0
+		)`, table)
+}
+
+func (mysqlDialect) AddColumnSQL(table, column, definition string) string {
+	return addColumnSQL(table, column, definition)
+}
+
+func (mysqlDialect) Placeholder(int) string { return "?" }
+
+func (mysqlDialect) QuoteIdentifier(name string) string { return quoteIdentifier(name, "`") }
+
+// TransactionalDDL is false because MySQL commits implicitly before and after DDL.
+func (mysqlDialect) TransactionalDDL() bool { return false }
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) CreateHistoryTableSQL(table string) string {
+	return fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			checksum VARCHAR(64),
+			dirty INTEGER NOT NULL DEFAULT 0,
+			out_of_order INTEGER NOT NULL DEFAULT 0,
+			sequence INTEGER NOT NULL DEFAULT 0,
+			batch INTEGER NOT NULL DEFAULT 0
+		)`, table)
+}
+
+func (sqliteDialect) AddColumnSQL(table, column, definition string) string {
+	return addColumnSQL(table, column, definition)
+}
+
+func (sqliteDialect) Placeholder(int) string { return "?" }
+
+func (sqliteDialect) QuoteIdentifier(name string) string { return quoteIdentifier(name, `"`) }
+
+func (sqliteDialect) TransactionalDDL() bool { return true }
+
+type sqlserverDialect struct{}
+
+func (sqlserverDialect) CreateHistoryTableSQL(table string) string {
+	return fmt.Sprintf(`
+		IF OBJECT_ID(N'%s', N'U') IS NULL
+		CREATE TABLE %s (
+			version BIGINT PRIMARY KEY,
+			name NVARCHAR(255) NOT NULL,
+			applied_at DATETIME2 DEFAULT SYSUTCDATETIME(),
+			checksum VARCHAR(64),
+			dirty INTEGER NOT NULL DEFAULT 0,
+			out_of_order INTEGER NOT NULL DEFAULT 0,
+			sequence BIGINT NOT NULL DEFAULT 0,
+			batch BIGINT NOT NULL DEFAULT 0
+		)`, strings.ReplaceAll(table, "'", "''"), table)
+}
+
+// AddColumnSQL uses T-SQL's ALTER TABLE ... ADD, which has no COLUMN keyword.
+func (sqlserverDialect) AddColumnSQL(table, column, definition string) string {
+	return fmt.Sprintf("ALTER TABLE %s ADD %s %s", table, column, definition)
+}
+
+func (sqlserverDialect) Placeholder(n int) string { return fmt.Sprintf("@p%d", n) }
+
+func (sqlserverDialect) QuoteIdentifier(name string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = "[" + strings.ReplaceAll(part, "]", "]]") + "]"
+	}
+	return strings.Join(parts, ".")
+}
+
+func (sqlserverDialect) TransactionalDDL() bool { return true }
@@ -0,0 +1,88 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+)
+
+// DirtyError is returned by Migrate, MigrateTo and Rollback when an earlier
+// migration failed part way on a database without transactional DDL, leaving
+// the schema in an unknown state. Repair the database by hand and call Force
+// to record the version it is actually at.
+type DirtyError struct {
+	Version int64
+	Name    string
+}
+
+func (e *DirtyError) Error() string {
+	return fmt.Sprintf("migration %d (%s) failed part way and left the database dirty; "+
+		"repair it manually, then force the version it is at", e.Version, e.Name)
+}
+
+// checkDirty returns a *DirtyError if any migration is marked dirty
+func (m *Migrator) checkDirty(ctx context.Context) error {
+	records, err := m.appliedRecords(ctx)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		if record.dirty {
+			return &DirtyError{Version: record.version, Name: record.name}
+		}
+	}
+	return nil
+}
+
+// Force records the database as being at version without running any
+// migration SQL. Records above version are removed, loaded migrations up to
+// and including version are recorded as applied, and all dirty markers are
+// cleared. A version of 0 removes every record. It is meant for recovering
+// after an operator has repaired a dirty database by hand.
+func (m *Migrator) Force(version int64) error {
+	return m.ForceContext(context.Background(), version)
+}
+
+// ForceContext is like Force but binds its transaction to ctx.
+func (m *Migrator) ForceContext(ctx context.Context, version int64) error {
+	dialect, err := getDialect(m.config.DatabaseType)
+	if err != nil {
+		return err
+	}
+
+	if version != 0 && m.findMigration(version) == nil {
+		return fmt.Errorf("unknown migration version %d", version)
+	}
+
+	return m.withLock(ctx, dialect, func() error {
+		records, err := m.appliedRecords(ctx)
+		if err != nil {
+			return err
+		}
+		recorder := newRecorder(records, 0)
+
+		tx, err := m.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		table := m.table(dialect)
+		deleteSQL := fmt.Sprintf("DELETE FROM %s WHERE version > %s", table, dialect.Placeholder(1))
+		if _, err := tx.ExecContext(ctx, deleteSQL, version); err != nil {
+			return fmt.Errorf("failed to remove migration records above %d: %v", version, err)
+		}
+
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET dirty = 0", table)); err != nil {
+			return fmt.Errorf("failed to clear dirty markers: %v", err)
+		}
+
+		for _, migration := range m.pendingMigrations(appliedVersions(records), version) {
+			record := m.insertRecord(dialect, migration, recorder.next(migration))
+			if _, err := tx.ExecContext(ctx, record.query, record.args...); err != nil {
+				return fmt.Errorf("failed to record migration %d: %v", migration.Version, err)
+			}
+		}
+
+		return tx.Commit()
+	})
+}
@@ -0,0 +1,139 @@
+package migrations
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// historyStatement is a bookkeeping statement against the history table
+type historyStatement struct {
+	query string
+	args  []interface{}
+}
+
+// recordOptions are the facts insertRecord stores besides the migration itself
+type recordOptions struct {
+	dirty      bool  // started but not yet finished
+	outOfOrder bool  // applied after a newer migration
+	sequence   int64 // one above the last migration applied, ordering rollbacks
+	batch      int64 // shared by the migrations applied by one call
+}
+
+// recorder hands out the recordOptions of the migrations applied by one
+// Migrate, MigrateTo or Force call: consecutive apply sequences after the
+// last one recorded, a new batch, and the out-of-order flag for versions
+// below latest
+type recorder struct {
+	latest   int64
+	sequence int64
+	batch    int64
+}
+
+func newRecorder(records []appliedRecord, latest int64) *recorder {
+	r := &recorder{latest: latest, batch: lastBatch(records) + 1}
+	for _, record := range records {
+		if record.sequence > r.sequence {
+			r.sequence = record.sequence
+		}
+	}
+	return r
+}
+
+// next returns the options recording migration, the next one applied
+func (r *recorder) next(migration *Migration) recordOptions {
+	r.sequence++
+	return recordOptions{
+		outOfOrder: migration.Version < r.latest,
+		sequence:   r.sequence,
+		batch:      r.batch,
+	}
+}
+
+// insertRecord records migration as applied, or as started but not yet
+// finished when dirty is set
+func (m *Migrator) insertRecord(dialect Dialect, migration *Migration, options recordOptions) historyStatement {
+	return historyStatement{
+		query: fmt.Sprintf(
+			"INSERT INTO %s (version, name, applied_at, checksum, dirty, out_of_order, sequence, batch) VALUES (%s, %s, %s, %s, %d, %d, %d, %d)",
+			m.table(dialect),
+			dialect.Placeholder(1),
+			dialect.Placeholder(2),
+			dialect.Placeholder(3),
+			dialect.Placeholder(4),
+			boolInt(options.dirty),
+			boolInt(options.outOfOrder),
+			options.sequence,
+			options.batch,
+		),
+		args: []interface{}{migration.Version, migration.Name, time.Now(), migration.Checksum()},
+	}
+}
+
+// markClean marks a migration recorded as dirty by insertRecord as finished
+func (m *Migrator) markClean(dialect Dialect, migration *Migration) historyStatement {
+	return historyStatement{
+		query: fmt.Sprintf(
+			"UPDATE %s SET dirty = 0, applied_at = %s WHERE version = %s",
+			m.table(dialect),
+			dialect.Placeholder(1),
+			dialect.Placeholder(2),
+		),
+		args: []interface{}{time.Now(), migration.Version},
+	}
+}
+
+// markDirty marks an applied migration as being rolled back
+func (m *Migrator) markDirty(dialect Dialect, migration *Migration) historyStatement {
+	return historyStatement{
+		query: fmt.Sprintf("UPDATE %s SET dirty = 1 WHERE version = %s", m.table(dialect), dialect.Placeholder(1)),
+		args:  []interface{}{migration.Version},
+	}
+}
+
+// deleteRecord removes the record of a rolled back migration
+func (m *Migrator) deleteRecord(dialect Dialect, migration *Migration) historyStatement {
+	return historyStatement{
+		query: fmt.Sprintf("DELETE FROM %s WHERE version = %s", m.table(dialect), dialect.Placeholder(1)),
+		args:  []interface{}{migration.Version},
+	}
+}
+
+// render returns the statement with its arguments inlined as SQL literals, for
+// display only
+func (s historyStatement) render(dialect Dialect) string {
+	query := s.query
+	offset := 0
+	for i, arg := range s.args {
+		placeholder := dialect.Placeholder(i + 1)
+		pos := strings.Index(query[offset:], placeholder)
+		if pos < 0 {
+			break
+		}
+		pos += offset
+		literal := sqlLiteral(arg)
+		query = query[:pos] + literal + query[pos+len(placeholder):]
+		offset = pos + len(literal)
+	}
+	return query
+}
+
+// boolInt returns the 0 or 1 stored in the history table's flag columns
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// sqlLiteral formats a bookkeeping argument as a SQL literal
+func sqlLiteral(arg interface{}) string {
+	switch v := arg.(type) {
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	case time.Time:
+		return "'" + v.UTC().Format("2006-01-02 15:04:05") + "'"
+	default:
+		return fmt.Sprint(v)
+	}
+}
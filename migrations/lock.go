@@ -0,0 +1,316 @@
+package migrations
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"time"
+)
+
+// DefaultLockWaitTimeout is how long Migrate, MigrateTo and Rollback wait for
+// the migration lock when Config.LockWaitTimeout is zero.
+const DefaultLockWaitTimeout = 15 * time.Second
+
+// ErrLockTimeout is returned when the migration lock is held by another
+// process for longer than the configured wait timeout.
+var ErrLockTimeout = errors.New("timed out waiting for migration lock")
+
+// withLock runs fn while holding the database-level migration lock, so that
+// concurrent processes migrating the same database run one after another.
+func (m *Migrator) withLock(ctx context.Context, dialect Dialect, fn func() error) (err error) {
+	timeout := m.config.LockWaitTimeout
+	if timeout <= 0 {
+		timeout = DefaultLockWaitTimeout
+	}
+
+	m.logger.Debug("acquiring migration lock", "timeout", timeout)
+	unlock, err := dialect.Lock(ctx, m.db, m.tableName(), timeout)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if unlockErr := unlock(); unlockErr != nil && err == nil {
+			err = fmt.Errorf("failed to release migration lock: %v", unlockErr)
+		}
+	}()
+
+	return fn()
+}
+
+// pollLock calls try until it acquires the lock, returning ErrLockTimeout once
+// timeout has passed
+func pollLock(ctx context.Context, timeout time.Duration, try func() (bool, error)) error {
+	deadline := time.Now().Add(timeout)
+	interval := 50 * time.Millisecond
+	for {
+		acquired, err := try()
+		if err != nil {
+			return fmt.Errorf("failed to acquire migration lock: %v", err)
+		}
+		if acquired {
+			return nil
+		}
+		if time.Now().Add(interval).After(deadline) {
+			return fmt.Errorf("%w after %s", ErrLockTimeout, timeout)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for migration lock: %w", ctx.Err())
+		case <-time.After(interval):
+		}
+		if interval < time.Second {
+			interval *= 2
+		}
+	}
+}
+
+// Lock takes a session-level advisory lock, keyed by a hash of name, on a
+// dedicated connection.
+func (postgresDialect) Lock(ctx context.Context, db *sql.DB, name string, timeout time.Duration) (func() error, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	key := int64(h.Sum64())
+
+	err = pollLock(ctx, timeout, func() (bool, error) {
+		var acquired bool
+		err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&acquired)
+		return acquired, err
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return func() error {
+		defer conn.Close()
+		_, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", key)
+		return err
+	}, nil
+}
+
+// cockroachLeaseDuration is how long a CockroachDB migration lock stays valid
+// without being renewed; a process that dies while holding it blocks others
+// for at most this long.
+const cockroachLeaseDuration = 30 * time.Second
+
+// Lock claims a lease on the single row of the name_lock table, renewing it in
+// the background until unlocked. CockroachDB has no advisory locks, and the
+// lease expires on its own if the holder dies.
+func (d cockroachDialect) Lock(ctx context.Context, db *sql.DB, name string, timeout time.Duration) (func() error, error) {
+	lockTable := d.QuoteIdentifier(name + "_lock")
+	createSQL := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			id INTEGER PRIMARY KEY,
+			holder TEXT NOT NULL,
+			expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+		)`, lockTable)
+	if _, err := db.ExecContext(ctx, createSQL); err != nil {
+		return nil, err
+	}
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	holder := hex.EncodeToString(token)
+	lease := int64(cockroachLeaseDuration / time.Second)
+
+	// Take the row if it is free or its lease has run out
+	acquireSQL := fmt.Sprintf(`
+		INSERT INTO %[1]s (id, holder, expires_at) VALUES (1, $1, now() + $2 * INTERVAL '1 second')
+		ON CONFLICT (id) DO UPDATE SET holder = excluded.holder, expires_at = excluded.expires_at
+		WHERE %[1]s.expires_at < now()`, lockTable)
+	err := pollLock(ctx, timeout, func() (bool, error) {
+		result, err := db.ExecContext(ctx, acquireSQL, holder, lease)
+		if err != nil {
+			return false, err
+		}
+		n, err := result.RowsAffected()
+		return n == 1, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	renewSQL := fmt.Sprintf("UPDATE %s SET expires_at = now() + $2 * INTERVAL '1 second' WHERE id = 1 AND holder = $1", lockTable)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(cockroachLeaseDuration / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				// A failed renewal is retried on the next tick; the lease
+				// only lapses if every renewal within it fails
+				db.ExecContext(context.Background(), renewSQL, holder, lease)
+			}
+		}
+	}()
+
+	return func() error {
+		close(stop)
+		<-done
+		_, err := db.ExecContext(context.Background(),
+			fmt.Sprintf("DELETE FROM %s WHERE id = 1 AND holder = $1", lockTable), holder)
+		return err
+	}, nil
+}
+
+// Lock takes a named lock with GET_LOCK, scoped to the current database, on a
+// dedicated connection.
+func (mysqlDialect) Lock(ctx context.Context, db *sql.DB, name string, timeout time.Duration) (func() error, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.QueryRowContext(ctx, "SELECT CONCAT(DATABASE(), '.', ?)", name).Scan(&name); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// GET_LOCK waits on the server side and returns 0 when the timeout expires
+	var acquired sql.NullInt64
+	seconds := int(math.Ceil(timeout.Seconds()))
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", name, seconds).Scan(&acquired); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to acquire migration lock: %v", err)
+	}
+	if acquired.Int64 != 1 {
+		conn.Close()
+		return nil, fmt.Errorf("%w after %s", ErrLockTimeout, timeout)
+	}
+
+	return func() error {
+		defer conn.Close()
+		_, err := conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", name)
+		return err
+	}, nil
+}
+
+// sqliteLeaseDuration is how long a SQLite migration lock stays valid without
+// being renewed. It is longer than CockroachDB's as renewals cannot write
+// while a migration holds the database's write lock.
+const sqliteLeaseDuration = time.Minute
+
+// Lock claims the single row of the name_lock table, renewing its locked_at
+// in the background until unlocked. SQLite has no session locks, so a row
+// left behind by a process that died is taken over once locked_at is older
+// than sqliteLeaseDuration. locked_at also identifies the holder: renewals
+// and the unlock only touch the row while it still has the value last written.
+func (d sqliteDialect) Lock(ctx context.Context, db *sql.DB, name string, timeout time.Duration) (func() error, error) {
+	lockTable := d.QuoteIdentifier(name + "_lock")
+	createSQL := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			id INTEGER PRIMARY KEY,
+			locked_at TIMESTAMP NOT NULL
+		)`, lockTable)
+	if _, err := db.ExecContext(ctx, createSQL); err != nil {
+		return nil, err
+	}
+
+	// Take the row if it is free or its lease has run out
+	acquireSQL := fmt.Sprintf(`
+		INSERT INTO %[1]s (id, locked_at) VALUES (1, ?)
+		ON CONFLICT (id) DO UPDATE SET locked_at = excluded.locked_at
+		WHERE julianday(%[1]s.locked_at) < julianday(?)`, lockTable)
+	var lockedAt time.Time
+	err := pollLock(ctx, timeout, func() (bool, error) {
+		now := time.Now().UTC()
+		result, err := db.ExecContext(ctx, acquireSQL, now, now.Add(-sqliteLeaseDuration))
+		if err != nil {
+			return false, err
+		}
+		n, err := result.RowsAffected()
+		lockedAt = now
+		return n == 1, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	renewSQL := fmt.Sprintf("UPDATE %s SET locked_at = ? WHERE id = 1 AND locked_at = ?", lockTable)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(sqliteLeaseDuration / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				// A failed renewal, such as while a migration holds the
+				// write lock, is retried on the next tick
+				now := time.Now().UTC()
+				result, err := db.ExecContext(context.Background(), renewSQL, now, lockedAt)
+				if err != nil {
+					continue
+				}
+				if n, err := result.RowsAffected(); err == nil && n == 1 {
+					lockedAt = now
+				}
+			}
+		}
+	}()
+
+	return func() error {
+		close(stop)
+		<-done
+		_, err := db.ExecContext(context.Background(),
+			fmt.Sprintf("DELETE FROM %s WHERE id = 1 AND locked_at = ?", lockTable), lockedAt)
+		return err
+	}, nil
+}
+
+// Lock takes a session-owned application lock with sp_getapplock on a
+// dedicated connection. Application locks are scoped to the current database.
+func (sqlserverDialect) Lock(ctx context.Context, db *sql.DB, name string, timeout time.Duration) (func() error, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// sp_getapplock waits on the server side; it returns 0 or 1 when granted
+	// and -1 when the timeout expires
+	lockSQL := `
+		DECLARE @result INT;
+		EXEC @result = sp_getapplock @Resource = @p1, @LockMode = 'Exclusive', @LockOwner = 'Session', @LockTimeout = @p2;
+		SELECT @result;`
+	var result int
+	if err := conn.QueryRowContext(ctx, lockSQL, name, timeout.Milliseconds()).Scan(&result); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to acquire migration lock: %v", err)
+	}
+	if result == -1 {
+		conn.Close()
+		return nil, fmt.Errorf("%w after %s", ErrLockTimeout, timeout)
+	}
+	if result < 0 {
+		conn.Close()
+		return nil, fmt.Errorf("failed to acquire migration lock: sp_getapplock returned %d", result)
+	}
+
+	return func() error {
+		defer conn.Close()
+		_, err := conn.ExecContext(context.Background(),
+			"EXEC sp_releaseapplock @Resource = @p1, @LockOwner = 'Session'", name)
+		return err
+	}, nil
+}
@@ -1,219 +1,495 @@
 package migrations
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
+	"io"
+	"log/slog"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
 type Config struct {
 	DatabaseType string
+
+	// TableName is the table recording applied migrations. It may be
+	// schema-qualified and defaults to DefaultTableName.
+	TableName string
+
+	// ValidateOnMigrate makes Migrate and MigrateTo run Validate first and
+	// refuse to apply anything if an applied migration has drifted.
+	ValidateOnMigrate bool
+
+	// LockWaitTimeout is how long to wait for another process holding the
+	// migration lock. Zero means DefaultLockWaitTimeout.
+	LockWaitTimeout time.Duration
+
+	// StatementTimeout and LockTimeout limit how long each migration
+	// statement may run and wait for table or row locks: statement_timeout
+	// and lock_timeout on PostgreSQL, max_execution_time and
+	// innodb_lock_wait_timeout on MySQL, busy_timeout on SQLite. Zero keeps
+	// the database default. Migration files can override them with
+	// "-- migrate:statement-timeout 1m" and "-- migrate:lock-timeout 5s".
+	StatementTimeout time.Duration
+	LockTimeout      time.Duration
+
+	// AllowOutOfOrder lets Migrate and MigrateTo apply pending migrations
+	// older than the newest applied one instead of returning an
+	// *OutOfOrderError. They are flagged as out of order in the history table.
+	AllowOutOfOrder bool
+
+	// Logger receives progress messages with version, name, direction and
+	// duration attributes. A nil Logger discards them.
+	Logger *slog.Logger
+
+	// OnMigration, if set, is called with each migration applied or rolled
+	// back, in order, once it has committed. Use it rather than the Logger's
+	// messages to collect results, as the CLI does for -output=json.
+	OnMigration func(MigrationResult)
+}
+
+// MigrationResult is a migration applied or rolled back, as passed to
+// Config.OnMigration
+type MigrationResult struct {
+	Version   int64
+	Name      string
+	Direction string // "up" or "down"
+	Duration  time.Duration
 }
 
 // Migration represents a single database migration
 type Migration struct {
-	Version   int
-	Name      string
+	Version int64
+
+	// Name is the part of the file name between the version and the
+	// direction, such as "create_users", and is recorded in the name column
+	// of the history table. Releases before Source recorded the version
+	// itself, see recordedAs.
+	Name string
+
 	UpSQL     string
 	DownSQL   string
 	AppliedAt *time.Time
+
+	// UpFunc and DownFunc are set for migrations added with Register and
+	// take the place of UpSQL and DownSQL.
+	UpFunc   MigrationFunc
+	DownFunc MigrationFunc
+
+	// UpNoTransaction and DownNoTransaction are set by a
+	// "-- migrate:no-transaction" line in the leading comments of the up or
+	// down file. That direction then runs outside any transaction, for
+	// statements such as CREATE INDEX CONCURRENTLY, and is recorded as dirty
+	// until it has finished.
+	UpNoTransaction   bool
+	DownNoTransaction bool
+
+	// UpTimeouts and DownTimeouts override Config.StatementTimeout and
+	// Config.LockTimeout, set by "-- migrate:statement-timeout <duration>"
+	// and "-- migrate:lock-timeout <duration>" lines in the leading comments
+	// of the up or down file.
+	UpTimeouts   Timeouts
+	DownTimeouts Timeouts
 }
 
+// MigrationFunc is the body of a Go migration. It runs inside the migration's
+// transaction, so all database work must go through tx.
+type MigrationFunc func(ctx context.Context, tx *sql.Tx) error
+
 // Migrator handles database migrations
 type Migrator struct {
 	db            *sql.DB
 	migrationsDir string
+	source        Source
 	config        Config
+	logger        *slog.Logger
 	migrations    []*Migration
+	registered    []*Migration
 }
 
-// dbDialect encapsulates database-specific behaviors
-type dbDialect struct {
-	createTableSQL string
-	placeholder    func(int) string
-}
-
-// getDialect returns the appropriate dialect for the database type
-func getDialect(dbType string) (*dbDialect, error) {
-	dialects := map[string]dbDialect{
-		"postgres": {
-			createTableSQL: `
-				CREATE TABLE IF NOT EXISTS schema_migrations (
-					version INTEGER PRIMARY KEY,
-					name TEXT NOT NULL,
-					applied_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
-				)`,
-			placeholder: func(i int) string { return fmt.Sprintf("$%d", i) },
-		},
-		"mysql": {
-			createTableSQL: `
-				CREATE TABLE IF NOT EXISTS schema_migrations (
-					version INTEGER PRIMARY KEY,
-					name VARCHAR(255) NOT NULL,
-					applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-				)`,
-			placeholder: func(i int) string { return "?" },
-		},
-		"sqlite3": {
-			createTableSQL: `
-				CREATE TABLE IF NOT EXISTS schema_migrations (
-					version INTEGER PRIMARY KEY,
-					name TEXT NOT NULL,
-					applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-				)`,
-			placeholder: func(i int) string { return "?" },
-		},
-	}
-
-	dialect, ok := dialects[dbType]
-	if !ok {
-		return nil, fmt.Errorf("unsupported database type: %s", dbType)
-	}
-	return &dialect, nil
-}
+// DefaultTableName is the history table used when Config.TableName is empty
+const DefaultTableName = "schema_migrations"
 
+// New creates a Migrator that loads migration files from migrationsDir on disk
 func New(db *sql.DB, migrationsDir string, config Config) *Migrator {
+	m := NewWithSource(db, DirSource(migrationsDir), config)
+	m.migrationsDir = migrationsDir
+	return m
+}
+
+// NewWithSource creates a Migrator that loads migration files from source,
+// e.g. FSSource over an embed.FS
+func NewWithSource(db *sql.DB, source Source, config Config) *Migrator {
+	logger := config.Logger
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
 	return &Migrator{
-		db:            db,
-		migrationsDir: migrationsDir,
-		config:        config,
+		db:     db,
+		source: source,
+		config: config,
+		logger: logger,
 	}
 }
 
-// Init creates the migrations table if it doesn't exist
+// Init creates the migrations table if it doesn't exist, and upgrades one
+// created by an earlier release. It holds the migration lock, so replicas
+// calling Init on startup do not race to alter the table.
 func (m *Migrator) Init() error {
+	return m.InitContext(context.Background())
+}
+
+// InitContext is like Init but uses ctx for the table creation statement.
+func (m *Migrator) InitContext(ctx context.Context) error {
 	dialect, err := getDialect(m.config.DatabaseType)
 	if err != nil {
 		return err
 	}
 
-	_, err = m.db.Exec(dialect.createTableSQL)
-	return err
+	return m.withLock(ctx, dialect, func() error {
+		return m.init(ctx, dialect)
+	})
+}
+
+// init creates or upgrades the history table; the caller holds the migration lock
+func (m *Migrator) init(ctx context.Context, dialect Dialect) error {
+	table := m.table(dialect)
+	if _, err := m.db.ExecContext(ctx, dialect.CreateHistoryTableSQL(table)); err != nil {
+		return err
+	}
+
+	// Upgrade tables created by earlier releases
+	for _, column := range historyColumns {
+		if m.hasColumn(ctx, table, column.name) {
+			continue
+		}
+		if _, err := m.db.ExecContext(ctx, dialect.AddColumnSQL(table, column.name, column.definition)); err != nil {
+			// A process that does not take the lock, such as an earlier
+			// release, may have added it meanwhile
+			if m.hasColumn(ctx, table, column.name) {
+				continue
+			}
+			return fmt.Errorf("failed to add %s column to %s: %v", column.name, m.tableName(), err)
+		}
+	}
+	if widener, ok := dialect.(VersionWideningDialect); ok {
+		if err := widener.WidenVersionColumn(ctx, m.db, m.tableName()); err != nil {
+			return fmt.Errorf("failed to widen the version column of %s: %v", m.tableName(), err)
+		}
+	}
+	return nil
+}
+
+// hasColumn reports whether the quoted table has the named column
+func (m *Migrator) hasColumn(ctx context.Context, table, column string) bool {
+	rows, err := m.db.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM %s WHERE 1 = 0", column, table))
+	if err != nil {
+		return false
+	}
+	rows.Close()
+	return true
+}
+
+// historyColumns are the history table columns added after the original
+// version, name and applied_at layout. Init adds any that are missing.
+var historyColumns = []struct {
+	name       string
+	definition string
+}{
+	{"checksum", "VARCHAR(64)"},
+	{"dirty", "INTEGER NOT NULL DEFAULT 0"},
+	{"out_of_order", "INTEGER NOT NULL DEFAULT 0"},
+	{"sequence", "BIGINT NOT NULL DEFAULT 0"},
+	{"batch", "BIGINT NOT NULL DEFAULT 0"},
 }
 
-// LoadMigrations reads all migration files from the migrations directory
+// LoadMigrations reads all migration files from the migrator's Source
 func (m *Migrator) LoadMigrations() error {
-	files, err := os.ReadDir(m.migrationsDir)
+	return m.LoadMigrationsContext(context.Background())
+}
+
+// LoadMigrationsContext is like LoadMigrations but stops early if ctx is done.
+func (m *Migrator) LoadMigrationsContext(ctx context.Context) error {
+	files, err := m.source.Files()
 	if err != nil {
 		return err
 	}
 
 	// Group up and down files
-	migrationFiles := make(map[int]map[string]string)
+	migrationFiles := make(map[int64]*Migration)
 	for _, file := range files {
-		fmt.Println(file.Name(), strings.HasSuffix(file.Name(), ".sql"))
-		if strings.HasSuffix(file.Name(), ".sql") {
-			var version int
-			var _, direction string
-			version, _, direction, err := m.parseMigrationFilename(file.Name())
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if strings.HasSuffix(file, ".sql") {
+			version, name, direction, err := ParseFilename(file)
 			if err != nil {
+				m.logger.Debug("skipping migration file", "file", file, "error", err)
 				continue
 			}
+			m.logger.Debug("loading migration file", "file", file, "version", version, "direction", direction)
 
 			if migrationFiles[version] == nil {
-				migrationFiles[version] = make(map[string]string)
+				migrationFiles[version] = &Migration{Version: version, Name: name}
 			}
 
-			content, err := os.ReadFile(filepath.Join(m.migrationsDir, file.Name()))
+			content, err := m.source.ReadFile(file)
 			if err != nil {
 				return err
 			}
 
-			migrationFiles[version][direction] = string(content)
+			_, noTransaction := directive(string(content), "no-transaction")
+			timeouts, err := parseTimeouts(string(content))
+			if err != nil {
+				return fmt.Errorf("migration file %s: %v", file, err)
+			}
+			if direction == "up" {
+				migrationFiles[version].UpSQL = string(content)
+				migrationFiles[version].UpNoTransaction = noTransaction
+				migrationFiles[version].UpTimeouts = timeouts
+			} else {
+				migrationFiles[version].DownSQL = string(content)
+				migrationFiles[version].DownNoTransaction = noTransaction
+				migrationFiles[version].DownTimeouts = timeouts
+			}
 		}
 	}
 
-	// Create migration objects
-	for version, files := range migrationFiles {
-		m.migrations = append(m.migrations, &Migration{
-			Version: version,
-			Name:    fmt.Sprintf("%d", version),
-			UpSQL:   files["up"],
-			DownSQL: files["down"],
-		})
+	// Create migration objects, interleaved with the registered Go migrations
+	var loaded []*Migration
+	for _, migration := range migrationFiles {
+		loaded = append(loaded, migration)
+	}
+	for _, migration := range m.registered {
+		if _, ok := migrationFiles[migration.Version]; ok {
+			return fmt.Errorf("migration %d is defined both by SQL files and by Register", migration.Version)
+		}
+		loaded = append(loaded, migration)
+	}
+	m.migrations = loaded
+	m.sortMigrations()
+
+	return nil
+}
+
+// Register adds a migration implemented in Go. It is run in version order
+// together with the SQL migrations from LoadMigrations and recorded in
+// schema_migrations in the same way. down may be nil if the migration cannot
+// be reverted, in which case rolling it back only removes its record.
+func (m *Migrator) Register(version int64, name string, up, down MigrationFunc) error {
+	if up == nil {
+		return fmt.Errorf("migration %d: up function is required", version)
 	}
+	if m.findMigration(version) != nil {
+		return fmt.Errorf("migration %d is already defined", version)
+	}
+
+	migration := &Migration{
+		Version:  version,
+		Name:     name,
+		UpFunc:   up,
+		DownFunc: down,
+	}
+	m.registered = append(m.registered, migration)
+	m.migrations = append(m.migrations, migration)
+	m.sortMigrations()
+	return nil
+}
 
-	// Sort migrations by version
+// recordedAs reports whether name, as recorded in the history table, is
+// mg's. Rows written before names were parsed from file names hold the
+// version instead, such as "1", and still match.
+func (mg *Migration) recordedAs(name string) bool {
+	return name == mg.Name || name == strconv.FormatInt(mg.Version, 10)
+}
+
+// sortMigrations sorts migrations by version
+func (m *Migrator) sortMigrations() {
 	sort.Slice(m.migrations, func(i, j int) bool {
 		return m.migrations[i].Version < m.migrations[j].Version
 	})
-
-	return nil
 }
 
 // GetAppliedMigrations retrieves all applied migrations from the database
-func (m *Migrator) GetAppliedMigrations() (map[int]time.Time, error) {
-	rows, err := m.db.Query("SELECT version, applied_at FROM schema_migrations ORDER BY version")
+func (m *Migrator) GetAppliedMigrations() (map[int64]time.Time, error) {
+	return m.GetAppliedMigrationsContext(context.Background())
+}
+
+// GetAppliedMigrationsContext is like GetAppliedMigrations but uses ctx for the query.
+func (m *Migrator) GetAppliedMigrationsContext(ctx context.Context) (map[int64]time.Time, error) {
+	records, err := m.appliedRecords(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return appliedVersions(records), nil
+}
+
+// appliedVersions maps the versions of records to when they were applied
+func appliedVersions(records []appliedRecord) map[int64]time.Time {
+	applied := make(map[int64]time.Time, len(records))
+	for _, record := range records {
+		applied[record.version] = record.appliedAt
+	}
+	return applied
+}
+
+// appliedRecord is a row of the history table
+type appliedRecord struct {
+	version    int64
+	name       string
+	appliedAt  time.Time
+	checksum   string
+	dirty      bool
+	outOfOrder bool
+	sequence   int64
+	batch      int64
+}
+
+// appliedRecords reads the history table ordered by version
+func (m *Migrator) appliedRecords(ctx context.Context) ([]appliedRecord, error) {
+	dialect, err := getDialect(m.config.DatabaseType)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf("SELECT version, name, applied_at, checksum, dirty, out_of_order, sequence, batch FROM %s ORDER BY version", m.table(dialect))
+	rows, err := m.db.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	applied := make(map[int]time.Time)
+	var records []appliedRecord
 	for rows.Next() {
-		var version int
-		var appliedAt time.Time
-		if err := rows.Scan(&version, &appliedAt); err != nil {
+		var record appliedRecord
+		var checksum sql.NullString
+		if err := rows.Scan(&record.version, &record.name, &record.appliedAt, &checksum, &record.dirty, &record.outOfOrder, &record.sequence, &record.batch); err != nil {
 			return nil, err
 		}
-		applied[version] = appliedAt
+		record.checksum = checksum.String
+		records = append(records, record)
 	}
 
-	return applied, nil
+	return records, rows.Err()
 }
 
-// Migrate runs all pending migrations
+// Migrate runs all pending migrations. Like MigrateTo and Rollback, it holds a
+// database-level lock while running so concurrent callers do not race.
 func (m *Migrator) Migrate() error {
+	return m.MigrateContext(context.Background())
+}
+
+// MigrateContext runs all pending migrations, each in its own transaction bound
+// to ctx. If ctx is canceled or its deadline passes while a migration is running,
+// that migration's transaction is rolled back and the returned error names it and
+// wraps ctx.Err(). Migrations committed before the interruption stay applied.
+func (m *Migrator) MigrateContext(ctx context.Context) error {
 	dialect, err := getDialect(m.config.DatabaseType)
 	if err != nil {
 		return err
 	}
 
-	applied, err := m.GetAppliedMigrations()
+	return m.withLock(ctx, dialect, func() error {
+		return m.migrate(ctx, dialect)
+	})
+}
+
+// migrate runs all pending migrations; the caller holds the migration lock
+func (m *Migrator) migrate(ctx context.Context, dialect Dialect) error {
+	if err := m.checkDirty(ctx); err != nil {
+		return err
+	}
+
+	if m.config.ValidateOnMigrate {
+		if err := m.ValidateContext(ctx); err != nil {
+			return err
+		}
+	}
+
+	records, err := m.appliedRecords(ctx)
+	if err != nil {
+		return err
+	}
+	applied := appliedVersions(records)
+
+	pending := m.pendingMigrations(applied, -1)
+	latest, err := m.checkOrder(applied, pending, -1)
 	if err != nil {
 		return err
 	}
 
 	// Run pending migrations
-	for _, migration := range m.migrations {
-		if _, ok := applied[migration.Version]; !ok {
-			// Start transaction
-			tx, err := m.db.Begin()
-			if err != nil {
-				return err
-			}
+	recorder := newRecorder(records, latest)
+	for _, migration := range pending {
+		if err := m.applyMigration(ctx, dialect, migration, recorder.next(migration)); err != nil {
+			return err
+		}
+	}
 
-			// Apply migration
-			if _, err := tx.Exec(migration.UpSQL); err != nil {
-				tx.Rollback()
-				return fmt.Errorf("failed to apply migration %d: %v", migration.Version, err)
-			}
+	return nil
+}
 
-			// Record migration using database-specific placeholders
-			insertSQL := fmt.Sprintf(
-				"INSERT INTO schema_migrations (version, name, applied_at) VALUES (%s, %s, %s)",
-				dialect.placeholder(1),
-				dialect.placeholder(2),
-				dialect.placeholder(3),
-			)
+// MigrateTo brings the database to the given version. Pending migrations up to
+// and including version are applied; if the database is ahead, the applied
+// migrations above version are rolled back in a single transaction, newest
+// first. A version of 0 rolls back every applied migration.
+func (m *Migrator) MigrateTo(version int64) error {
+	return m.MigrateToContext(context.Background(), version)
+}
 
-			_, err = tx.Exec(insertSQL, migration.Version, migration.Name, time.Now())
-			if err != nil {
-				tx.Rollback()
-				return fmt.Errorf("failed to record migration %d: %v", migration.Version, err)
-			}
+// MigrateToContext is like MigrateTo but binds its transactions to ctx.
+func (m *Migrator) MigrateToContext(ctx context.Context, version int64) error {
+	dialect, err := getDialect(m.config.DatabaseType)
+	if err != nil {
+		return err
+	}
 
-			// Commit transaction
-			if err := tx.Commit(); err != nil {
-				return err
-			}
+	return m.withLock(ctx, dialect, func() error {
+		return m.migrateTo(ctx, dialect, version)
+	})
+}
 
-			fmt.Printf("Applied migration %d: %s\n", migration.Version, migration.Name)
+// migrateTo brings the database to version; the caller holds the migration lock
+func (m *Migrator) migrateTo(ctx context.Context, dialect Dialect, version int64) error {
+	if err := m.checkDirty(ctx); err != nil {
+		return err
+	}
+
+	if m.config.ValidateOnMigrate {
+		if err := m.ValidateContext(ctx); err != nil {
+			return err
+		}
+	}
+
+	records, err := m.appliedRecords(ctx)
+	if err != nil {
+		return err
+	}
+	applied := appliedVersions(records)
+
+	migrationsToRollback, migrationsToApply, err := m.migrateToMigrations(applied, version)
+	if err != nil {
+		return err
+	}
+	latest, err := m.checkOrder(applied, migrationsToApply, version)
+	if err != nil {
+		return err
+	}
+
+	if len(migrationsToRollback) > 0 {
+		if err := m.revertMigrations(ctx, dialect, migrationsToRollback); err != nil {
+			return err
+		}
+	}
+	recorder := newRecorder(records, latest)
+	for _, migration := range migrationsToApply {
+		if err := m.applyMigration(ctx, dialect, migration, recorder.next(migration)); err != nil {
+			return err
 		}
 	}
 
@@ -223,6 +499,13 @@ func (m *Migrator) Migrate() error {
 // Rollback reverts the last `steps` applied migrations in a single transaction.
 // If steps is less than 1, it defaults to 1.
 func (m *Migrator) Rollback(steps int) error {
+	return m.RollbackContext(context.Background(), steps)
+}
+
+// RollbackContext is like Rollback but binds the transaction to ctx. If ctx is
+// canceled or times out, none of the rollbacks are kept and the returned error
+// names the migration that was being reverted and wraps ctx.Err().
+func (m *Migrator) RollbackContext(ctx context.Context, steps int) error {
 	if steps < 1 {
 		steps = 1
 	}
@@ -232,65 +515,488 @@ func (m *Migrator) Rollback(steps int) error {
 		return err
 	}
 
-	applied, err := m.GetAppliedMigrations()
-	if err != nil {
+	return m.withLock(ctx, dialect, func() error {
+		return m.rollback(ctx, dialect, steps)
+	})
+}
+
+// rollback reverts the last steps applied migrations; the caller holds the migration lock
+func (m *Migrator) rollback(ctx context.Context, dialect Dialect, steps int) error {
+	if err := m.checkDirty(ctx); err != nil {
 		return err
 	}
 
-	if len(applied) == 0 {
-		return errors.New("no migrations to rollback")
+	records, err := m.appliedRecords(ctx)
+	if err != nil {
+		return err
 	}
 
-	// Build a list of applied migrations along with their applied times.
-	type appliedMigration struct {
-		migration *Migration
-		appliedAt time.Time
+	migrationsToRollback, err := m.rollbackMigrations(records, steps)
+	if err != nil {
+		return err
 	}
-	var appliedList []appliedMigration
+
+	return m.revertMigrations(ctx, dialect, migrationsToRollback)
+}
+
+// pendingMigrations returns the loaded migrations that are not applied, in
+// version order, stopping after upTo unless it is negative
+func (m *Migrator) pendingMigrations(applied map[int64]time.Time, upTo int64) []*Migration {
+	var pending []*Migration
 	for _, migration := range m.migrations {
-		if appliedAt, ok := applied[migration.Version]; ok {
-			appliedList = append(appliedList, appliedMigration{
-				migration: migration,
-				appliedAt: appliedAt,
-			})
+		if upTo >= 0 && migration.Version > upTo {
+			break
+		}
+		if _, ok := applied[migration.Version]; !ok {
+			pending = append(pending, migration)
 		}
 	}
+	return pending
+}
+
+// migrateToMigrations resolves which migrations MigrateTo rolls back, newest
+// version first, and which it then applies to reach version
+func (m *Migrator) migrateToMigrations(applied map[int64]time.Time, version int64) (down, up []*Migration, err error) {
+	if version != 0 && m.findMigration(version) == nil {
+		return nil, nil, fmt.Errorf("unknown migration version %d", version)
+	}
 
-	// Sort by applied time descending (most recent first).
-	sort.Slice(appliedList, func(i, j int) bool {
-		return appliedList[i].appliedAt.After(appliedList[j].appliedAt)
+	// Roll back anything applied above the target, newest version first
+	for v := range applied {
+		if v <= version {
+			continue
+		}
+		migration := m.findMigration(v)
+		if migration == nil {
+			return nil, nil, fmt.Errorf("migration %d is applied but was not loaded, cannot roll back past it", v)
+		}
+		down = append(down, migration)
+	}
+	sort.Slice(down, func(i, j int) bool {
+		return down[i].Version > down[j].Version
 	})
 
-	// Adjust steps if more than available.
-	if steps > len(appliedList) {
-		steps = len(appliedList)
+	// Apply pending migrations up to and including the target
+	return down, m.pendingMigrations(applied, version), nil
+}
+
+// rollbackMigrations resolves the last steps applied migrations, most recent
+// first by apply sequence. Records from before sequences were recorded share
+// sequence 0 and are taken newest version first.
+func (m *Migrator) rollbackMigrations(records []appliedRecord, steps int) ([]*Migration, error) {
+	if len(records) == 0 {
+		return nil, errors.New("no migrations to rollback")
 	}
 
 	var migrationsToRollback []*Migration
-	for i := 0; i < steps; i++ {
-		migrationsToRollback = append(migrationsToRollback, appliedList[i].migration)
+	for _, record := range newestFirst(records) {
+		if len(migrationsToRollback) == steps {
+			break
+		}
+		migration := m.findMigration(record.version)
+		if migration == nil {
+			return nil, fmt.Errorf("migration %d is applied but was not loaded, cannot roll it back", record.version)
+		}
+		migrationsToRollback = append(migrationsToRollback, migration)
+	}
+
+	return migrationsToRollback, nil
+}
+
+// newestFirst returns records sorted by apply sequence, most recent first,
+// and by version among records sharing a sequence
+func newestFirst(records []appliedRecord) []appliedRecord {
+	sorted := append([]appliedRecord(nil), records...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].sequence != sorted[j].sequence {
+			return sorted[i].sequence > sorted[j].sequence
+		}
+		return sorted[i].version > sorted[j].version
+	})
+	return sorted
+}
+
+// applyMigration runs a single migration and records it in its own
+// transaction with options
+func (m *Migrator) applyMigration(ctx context.Context, dialect Dialect, migration *Migration, options recordOptions) error {
+	if err := ctx.Err(); err != nil {
+		return interruptedErr(migration, err)
+	}
+	start := time.Now()
+	if options.outOfOrder {
+		m.logger.Warn("applying migration out of order", "version", migration.Version, "name", migration.Name)
+	}
+
+	// Without transactional DDL, or outside any transaction, a failure can
+	// leave the migration half applied, so record it as dirty up front and only
+	// mark it clean once it succeeds. The migration then runs apart from the
+	// bookkeeping.
+	transactional := dialect.TransactionalDDL() && !migration.UpNoTransaction
+	if !transactional {
+		dirty := options
+		dirty.dirty = true
+		record := m.insertRecord(dialect, migration, dirty)
+		if _, err := m.db.ExecContext(ctx, record.query, record.args...); err != nil {
+			return migrationErr(ctx, "failed to record migration", migration, err)
+		}
+	}
+
+	timeouts := m.timeouts(migration.UpTimeouts)
+	if migration.UpNoTransaction {
+		if err := m.execNoTransaction(ctx, dialect, migration.UpSQL, timeouts); err != nil {
+			return migrationErr(ctx, "failed to apply migration", migration, err)
+		}
+	} else {
+		// Start transaction
+		tx, err := m.db.BeginTx(ctx, nil)
+		if err != nil {
+			return migrationErr(ctx, "failed to apply migration", migration, err)
+		}
+		resetTimeouts, err := setTimeouts(ctx, dialect, tx, timeouts)
+		if err != nil {
+			tx.Rollback()
+			return migrationErr(ctx, "failed to apply migration", migration, err)
+		}
+
+		// Apply migration
+		if err := migration.up(ctx, dialect, tx); err != nil {
+			resetTimeouts()
+			tx.Rollback()
+			return migrationErr(ctx, "failed to apply migration", migration, err)
+		}
+
+		// Record migration
+		if transactional {
+			record := m.insertRecord(dialect, migration, options)
+			if _, err := tx.ExecContext(ctx, record.query, record.args...); err != nil {
+				resetTimeouts()
+				tx.Rollback()
+				return migrationErr(ctx, "failed to record migration", migration, err)
+			}
+		}
+
+		if err := resetTimeouts(); err != nil {
+			tx.Rollback()
+			return migrationErr(ctx, "failed to apply migration", migration, err)
+		}
+
+		// Commit transaction
+		if err := tx.Commit(); err != nil {
+			return migrationErr(ctx, "failed to commit migration", migration, err)
+		}
+	}
+
+	if !transactional {
+		clean := m.markClean(dialect, migration)
+		if _, err := m.db.ExecContext(ctx, clean.query, clean.args...); err != nil {
+			return migrationErr(ctx, "failed to record migration", migration, err)
+		}
+	}
+
+	m.migrated(migration, "up", time.Since(start))
+	return nil
+}
+
+// revertMigrations rolls back the given migrations, in order. Consecutive
+// migrations are reverted together in a single transaction, except without
+// transactional DDL or for a down migration marked no-transaction, which are
+// reverted on their own by revertMigration.
+func (m *Migrator) revertMigrations(ctx context.Context, dialect Dialect, migrations []*Migration) error {
+	var batch []*Migration
+	for _, migration := range migrations {
+		if dialect.TransactionalDDL() && !migration.DownNoTransaction {
+			batch = append(batch, migration)
+			continue
+		}
+		if err := m.revertBatch(ctx, dialect, batch); err != nil {
+			return err
+		}
+		batch = nil
+		if err := m.revertMigration(ctx, dialect, migration); err != nil {
+			return err
+		}
+	}
+	return m.revertBatch(ctx, dialect, batch)
+}
+
+// revertBatch rolls back migrations, in order, in a single transaction
+func (m *Migrator) revertBatch(ctx context.Context, dialect Dialect, migrations []*Migration) error {
+	if len(migrations) == 0 {
+		return nil
 	}
 
-	tx, err := m.db.Begin()
+	tx, err := m.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	for _, migration := range migrationsToRollback {
-		if _, err := tx.Exec(migration.DownSQL); err != nil {
+	durations := make([]time.Duration, len(migrations))
+	for i, migration := range migrations {
+		start := time.Now()
+		resetTimeouts, err := setTimeouts(ctx, dialect, tx, m.timeouts(migration.DownTimeouts))
+		if err != nil {
+			tx.Rollback()
+			return migrationErr(ctx, "failed to rollback migration", migration, err)
+		}
+		if err := migration.down(ctx, dialect, tx); err != nil {
+			resetTimeouts()
+			tx.Rollback()
+			return migrationErr(ctx, "failed to rollback migration", migration, err)
+		}
+		if err := resetTimeouts(); err != nil {
 			tx.Rollback()
-			return fmt.Errorf("failed to rollback migration %d: %v", migration.Version, err)
+			return migrationErr(ctx, "failed to rollback migration", migration, err)
 		}
-		deleteSQL := fmt.Sprintf("DELETE FROM schema_migrations WHERE version = %s", dialect.placeholder(1))
-		if _, err := tx.Exec(deleteSQL, migration.Version); err != nil {
+		remove := m.deleteRecord(dialect, migration)
+		if _, err := tx.ExecContext(ctx, remove.query, remove.args...); err != nil {
 			tx.Rollback()
-			return fmt.Errorf("failed to remove migration record %d: %v", migration.Version, err)
+			return migrationErr(ctx, "failed to remove migration record", migration, err)
+		}
+		durations[i] = time.Since(start)
+	}
+	if err := tx.Commit(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return fmt.Errorf("rollback interrupted: %w", ctxErr)
+		}
+		return err
+	}
+	for i, migration := range migrations {
+		m.migrated(migration, "down", durations[i])
+	}
+	return nil
+}
+
+// revertMigration rolls back a single migration in its own transaction, or
+// outside any for DownNoTransaction, with the bookkeeping apart from it: the
+// migration is marked dirty before its down migration runs and its record is
+// only removed once that has finished
+func (m *Migrator) revertMigration(ctx context.Context, dialect Dialect, migration *Migration) error {
+	if err := ctx.Err(); err != nil {
+		return interruptedErr(migration, err)
+	}
+	start := time.Now()
+
+	dirty := m.markDirty(dialect, migration)
+	if _, err := m.db.ExecContext(ctx, dirty.query, dirty.args...); err != nil {
+		return migrationErr(ctx, "failed to mark migration dirty", migration, err)
+	}
+
+	timeouts := m.timeouts(migration.DownTimeouts)
+	if migration.DownNoTransaction {
+		if err := m.execNoTransaction(ctx, dialect, migration.DownSQL, timeouts); err != nil {
+			return migrationErr(ctx, "failed to rollback migration", migration, err)
+		}
+	} else {
+		tx, err := m.db.BeginTx(ctx, nil)
+		if err != nil {
+			return migrationErr(ctx, "failed to rollback migration", migration, err)
+		}
+		resetTimeouts, err := setTimeouts(ctx, dialect, tx, timeouts)
+		if err != nil {
+			tx.Rollback()
+			return migrationErr(ctx, "failed to rollback migration", migration, err)
+		}
+		if err := migration.down(ctx, dialect, tx); err != nil {
+			resetTimeouts()
+			tx.Rollback()
+			return migrationErr(ctx, "failed to rollback migration", migration, err)
+		}
+		if err := resetTimeouts(); err != nil {
+			tx.Rollback()
+			return migrationErr(ctx, "failed to rollback migration", migration, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return migrationErr(ctx, "failed to commit rollback of migration", migration, err)
+		}
+	}
+
+	remove := m.deleteRecord(dialect, migration)
+	if _, err := m.db.ExecContext(ctx, remove.query, remove.args...); err != nil {
+		return migrationErr(ctx, "failed to remove migration record", migration, err)
+	}
+
+	m.migrated(migration, "down", time.Since(start))
+	return nil
+}
+
+// migrated logs a migration applied or rolled back in direction and passes it
+// to Config.OnMigration
+func (m *Migrator) migrated(migration *Migration, direction string, duration time.Duration) {
+	msg := "applied migration"
+	if direction == "down" {
+		msg = "rolled back migration"
+	}
+	m.logger.Info(msg,
+		"version", migration.Version,
+		"name", migration.Name,
+		"direction", direction,
+		"duration", duration,
+	)
+	if m.config.OnMigration != nil {
+		m.config.OnMigration(MigrationResult{
+			Version:   migration.Version,
+			Name:      migration.Name,
+			Direction: direction,
+			Duration:  duration,
+		})
+	}
+}
+
+// tableName returns the unquoted history table name
+func (m *Migrator) tableName() string {
+	if m.config.TableName != "" {
+		return m.config.TableName
+	}
+	return DefaultTableName
+}
+
+// table returns the history table name quoted for use in SQL
+func (m *Migrator) table(dialect Dialect) string {
+	return dialect.QuoteIdentifier(m.tableName())
+}
+
+// findMigration returns the loaded migration with the given version, or nil
+func (m *Migrator) findMigration(version int64) *Migration {
+	for _, migration := range m.migrations {
+		if migration.Version == version {
+			return migration
+		}
+	}
+	return nil
+}
+
+// up applies the migration within tx
+func (mg *Migration) up(ctx context.Context, dialect Dialect, tx *sql.Tx) error {
+	if mg.UpFunc != nil {
+		return mg.UpFunc(ctx, tx)
+	}
+	return execStatements(ctx, dialect, tx, mg.UpSQL)
+}
+
+// down reverts the migration within tx
+func (mg *Migration) down(ctx context.Context, dialect Dialect, tx *sql.Tx) error {
+	if mg.UpFunc != nil {
+		if mg.DownFunc == nil {
+			return nil
+		}
+		return mg.DownFunc(ctx, tx)
+	}
+	return execStatements(ctx, dialect, tx, mg.DownSQL)
+}
+
+// execNoTransaction runs the statements of a no-transaction migration on a
+// dedicated connection, so the timeouts apply to them and are reset afterwards
+func (m *Migrator) execNoTransaction(ctx context.Context, dialect Dialect, body string, timeouts Timeouts) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	resetTimeouts, err := setTimeouts(ctx, dialect, conn, timeouts)
+	if err != nil {
+		return err
+	}
+	if err := execStatements(ctx, dialect, conn, body); err != nil {
+		resetTimeouts()
+		return err
+	}
+	return resetTimeouts()
+}
+
+// execer is a *sql.Tx, or a *sql.Conn for migrations run outside a transaction
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// execStatements runs the statements of a SQL migration one at a time, so
+// drivers need no multi-statement support and a failure names its statement
+func execStatements(ctx context.Context, dialect Dialect, db execer, body string) error {
+	statements := splitStatements(dialect, body)
+	for i, statement := range statements {
+		if _, err := db.ExecContext(ctx, statement.SQL); err != nil {
+			return fmt.Errorf("statement %d of %d (line %d): %v", i+1, len(statements), statement.Line, err)
+		}
+	}
+	return nil
+}
+
+// MigrationError is returned when a migration fails to apply or roll back, or
+// is interrupted, so callers can tell which migration failed with errors.As.
+type MigrationError struct {
+	Version int64
+	Name    string
+	Err     error
+}
+
+func (e *MigrationError) Error() string { return e.Err.Error() }
+
+func (e *MigrationError) Unwrap() error { return e.Err }
+
+// migrationErr wraps err with the migration it occurred in. If ctx is done the
+// failure is reported as an interruption wrapping ctx.Err(), so callers can
+// detect it with errors.Is(err, context.Canceled) or context.DeadlineExceeded.
+func migrationErr(ctx context.Context, msg string, migration *Migration, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return interruptedErr(migration, ctxErr)
+	}
+	return &MigrationError{
+		Version: migration.Version,
+		Name:    migration.Name,
+		Err:     fmt.Errorf("%s %d: %v", msg, migration.Version, err),
+	}
+}
+
+func interruptedErr(migration *Migration, ctxErr error) error {
+	return &MigrationError{
+		Version: migration.Version,
+		Name:    migration.Name,
+		Err:     fmt.Errorf("migration %d (%s) interrupted: %w", migration.Version, migration.Name, ctxErr),
+	}
+}
+
+// directive looks for "-- migrate:<name>" among the comment lines heading a
+// migration file and returns the value following the name, if any
+func directive(content, name string) (value string, ok bool) {
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "--") {
+			return "", false
+		}
+		fields := strings.Fields(strings.TrimPrefix(line, "--"))
+		if len(fields) > 0 && strings.EqualFold(fields[0], "migrate:"+name) {
+			return strings.Join(fields[1:], " "), true
+		}
+	}
+	return "", false
+}
+
+// parseTimeouts reads the timeout directives of a migration file
+func parseTimeouts(content string) (Timeouts, error) {
+	var timeouts Timeouts
+	for name, timeout := range map[string]*time.Duration{
+		"statement-timeout": &timeouts.Statement,
+		"lock-timeout":      &timeouts.Lock,
+	} {
+		value, ok := directive(content, name)
+		if !ok {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return Timeouts{}, fmt.Errorf("invalid %s %q, expected a duration such as 5s", name, value)
 		}
-		fmt.Printf("Rolled back migration %d: %s\n", migration.Version, migration.Name)
+		*timeout = d
 	}
-	return tx.Commit()
+	return timeouts, nil
 }
 
-func (m *Migrator) parseMigrationFilename(filename string) (version int, name, direction string, err error) {
+// ParseFilename splits the name of a migration file such as
+// 001_create_users_up.sql or 20240131120000_create_users_up.sql into its
+// version, name and direction, "up" or "down".
+func ParseFilename(filename string) (version int64, name, direction string, err error) {
 	if !strings.HasSuffix(filename, ".sql") {
 		return 0, "", "", fmt.Errorf("file must have .sql extension")
 	}
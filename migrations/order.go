@@ -0,0 +1,49 @@
+package migrations
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// OutOfOrderError is returned by Migrate and MigrateTo when pending migrations
+// are older than the newest applied one, as when a branch adding migration 7
+// is merged after migration 8 was applied. Set Config.AllowOutOfOrder to
+// apply them anyway.
+type OutOfOrderError struct {
+	Latest     int64        // the newest applied version
+	Migrations []*Migration // the pending migrations older than Latest
+}
+
+func (e *OutOfOrderError) Error() string {
+	var lines []string
+	for _, migration := range e.Migrations {
+		lines = append(lines, fmt.Sprintf("migration %d (%s)", migration.Version, migration.Name))
+	}
+	return fmt.Sprintf("%d pending migration(s) are older than applied migration %d; "+
+		"allow out-of-order migrations to apply them:\n  %s",
+		len(e.Migrations), e.Latest, strings.Join(lines, "\n  "))
+}
+
+// checkOrder returns the newest applied version that will still be applied
+// once pending runs, ignoring those above upTo unless it is negative, as
+// MigrateTo rolls them back first. It returns an *OutOfOrderError if pending
+// holds older migrations and Config.AllowOutOfOrder is not set.
+func (m *Migrator) checkOrder(applied map[int64]time.Time, pending []*Migration, upTo int64) (latest int64, err error) {
+	for version := range applied {
+		if version > latest && (upTo < 0 || version <= upTo) {
+			latest = version
+		}
+	}
+
+	var older []*Migration
+	for _, migration := range pending {
+		if migration.Version < latest {
+			older = append(older, migration)
+		}
+	}
+	if len(older) > 0 && !m.config.AllowOutOfOrder {
+		return 0, &OutOfOrderError{Latest: latest, Migrations: older}
+	}
+	return latest, nil
+}
@@ -0,0 +1,242 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+)
+
+// PlanStep is a migration that would be applied or rolled back, together with
+// every statement that would run for it, in order, including the bookkeeping
+// against schema_migrations
+type PlanStep struct {
+	Version    int64
+	Name       string
+	Direction  string // "up" or "down"
+	Statements []string
+}
+
+// Plan resolves what Migrate would do without changing the database. It only
+// reads the migration state; if Init has not created schema_migrations yet,
+// every loaded migration is planned.
+func (m *Migrator) Plan() ([]PlanStep, error) {
+	return m.PlanContext(context.Background())
+}
+
+// PlanContext is like Plan but uses ctx for the database queries.
+func (m *Migrator) PlanContext(ctx context.Context) ([]PlanStep, error) {
+	dialect, records, err := m.planState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	applied := appliedVersions(records)
+	pending := m.pendingMigrations(applied, -1)
+	latest, err := m.checkOrder(applied, pending, -1)
+	if err != nil {
+		return nil, err
+	}
+	return m.upSteps(dialect, pending, newRecorder(records, latest)), nil
+}
+
+// PlanMigrateTo resolves what MigrateTo(version) would do without changing the database.
+func (m *Migrator) PlanMigrateTo(version int64) ([]PlanStep, error) {
+	return m.PlanMigrateToContext(context.Background(), version)
+}
+
+// PlanMigrateToContext is like PlanMigrateTo but uses ctx for the database queries.
+func (m *Migrator) PlanMigrateToContext(ctx context.Context, version int64) ([]PlanStep, error) {
+	dialect, records, err := m.planState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	applied := appliedVersions(records)
+	down, up, err := m.migrateToMigrations(applied, version)
+	if err != nil {
+		return nil, err
+	}
+	latest, err := m.checkOrder(applied, up, version)
+	if err != nil {
+		return nil, err
+	}
+	return append(m.downSteps(dialect, down), m.upSteps(dialect, up, newRecorder(records, latest))...), nil
+}
+
+// PlanRollback resolves what Rollback(steps) would do without changing the database.
+func (m *Migrator) PlanRollback(steps int) ([]PlanStep, error) {
+	return m.PlanRollbackContext(context.Background(), steps)
+}
+
+// PlanRollbackContext is like PlanRollback but uses ctx for the database queries.
+func (m *Migrator) PlanRollbackContext(ctx context.Context, steps int) ([]PlanStep, error) {
+	if steps < 1 {
+		steps = 1
+	}
+	dialect, records, err := m.planState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := m.rollbackMigrations(records, steps)
+	if err != nil {
+		return nil, err
+	}
+	return m.downSteps(dialect, migrations), nil
+}
+
+// PlanRollbackBatch resolves what RollbackBatch(batch) would do without
+// changing the database.
+func (m *Migrator) PlanRollbackBatch(batch int64) ([]PlanStep, error) {
+	return m.PlanRollbackBatchContext(context.Background(), batch)
+}
+
+// PlanRollbackBatchContext is like PlanRollbackBatch but uses ctx for the database queries.
+func (m *Migrator) PlanRollbackBatchContext(ctx context.Context, batch int64) ([]PlanStep, error) {
+	dialect, records, err := m.planState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := m.batchMigrations(records, batch)
+	if err != nil {
+		return nil, err
+	}
+	return m.downSteps(dialect, migrations), nil
+}
+
+// PlanRedo resolves what Redo(steps) would do without changing the database.
+// Like Redo, it reads the migration files again first.
+func (m *Migrator) PlanRedo(steps int) ([]PlanStep, error) {
+	return m.PlanRedoContext(context.Background(), steps)
+}
+
+// PlanRedoContext is like PlanRedo but uses ctx for the database queries.
+func (m *Migrator) PlanRedoContext(ctx context.Context, steps int) ([]PlanStep, error) {
+	if steps < 1 {
+		steps = 1
+	}
+	if err := m.LoadMigrationsContext(ctx); err != nil {
+		return nil, err
+	}
+	dialect, records, err := m.planRecords(ctx)
+	if err != nil {
+		return nil, err
+	}
+	down, up, err := m.redoMigrations(records, steps)
+	if err != nil {
+		return nil, err
+	}
+	recorder := newRecorder(records, redoLatest(records, down))
+	return append(m.downSteps(dialect, down), m.upSteps(dialect, up, recorder)...), nil
+}
+
+// planState reads the migration state and runs the same checks as the
+// corresponding Migrator methods, so a plan fails wherever the run would
+func (m *Migrator) planState(ctx context.Context) (Dialect, []appliedRecord, error) {
+	dialect, records, err := m.planRecords(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if m.config.ValidateOnMigrate {
+		if err := m.ValidateContext(ctx); err != nil {
+			return nil, nil, err
+		}
+	}
+	return dialect, records, nil
+}
+
+// planRecords is planState without the drift check, which Redo skips
+func (m *Migrator) planRecords(ctx context.Context) (Dialect, []appliedRecord, error) {
+	dialect, err := getDialect(m.config.DatabaseType)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Nothing has been applied to a database that was never initialized
+	rows, err := m.db.QueryContext(ctx, fmt.Sprintf("SELECT version FROM %s WHERE 1 = 0", m.table(dialect)))
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, nil, ctxErr
+		}
+		return dialect, nil, nil
+	}
+	rows.Close()
+
+	// A table from an earlier release lacks the columns read below until Init upgrades it
+	records, err := m.appliedRecords(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read migration state, run Init to upgrade %s: %v", m.tableName(), err)
+	}
+
+	if err := m.checkDirty(ctx); err != nil {
+		return nil, nil, err
+	}
+	return dialect, records, nil
+}
+
+// upSteps mirrors applyMigration, recording migrations as recorder hands out
+func (m *Migrator) upSteps(dialect Dialect, migrations []*Migration, recorder *recorder) []PlanStep {
+	var steps []PlanStep
+	for _, migration := range migrations {
+		var statements []string
+		if migration.UpNoTransaction {
+			statements = append(statements, "-- migrate:no-transaction")
+		}
+		options := recorder.next(migration)
+		record := m.insertRecord(dialect, migration, options)
+		if !dialect.TransactionalDDL() || migration.UpNoTransaction {
+			options.dirty = true
+			statements = append(statements, m.insertRecord(dialect, migration, options).render(dialect))
+			record = m.markClean(dialect, migration)
+		}
+		set, reset := timeoutSQL(dialect, m.timeouts(migration.UpTimeouts))
+		statements = append(statements, set...)
+		statements = append(statements, migrationStatements(dialect, migration, migration.UpSQL)...)
+		statements = append(statements, reset...)
+		statements = append(statements, record.render(dialect))
+
+		steps = append(steps, PlanStep{
+			Version:    migration.Version,
+			Name:       migration.Name,
+			Direction:  "up",
+			Statements: statements,
+		})
+	}
+	return steps
+}
+
+// downSteps mirrors revertMigrations
+func (m *Migrator) downSteps(dialect Dialect, migrations []*Migration) []PlanStep {
+	var steps []PlanStep
+	for _, migration := range migrations {
+		var statements []string
+		if migration.DownNoTransaction {
+			statements = append(statements, "-- migrate:no-transaction")
+		}
+		if !dialect.TransactionalDDL() || migration.DownNoTransaction {
+			statements = append(statements, m.markDirty(dialect, migration).render(dialect))
+		}
+		set, reset := timeoutSQL(dialect, m.timeouts(migration.DownTimeouts))
+		statements = append(statements, set...)
+		statements = append(statements, migrationStatements(dialect, migration, migration.DownSQL)...)
+		statements = append(statements, reset...)
+		statements = append(statements, m.deleteRecord(dialect, migration).render(dialect))
+
+		steps = append(steps, PlanStep{
+			Version:    migration.Version,
+			Name:       migration.Name,
+			Direction:  "down",
+			Statements: statements,
+		})
+	}
+	return steps
+}
+
+// migrationStatements is the text shown in a plan for the up or down body of
+// migration, one entry per statement that would run
+func migrationStatements(dialect Dialect, migration *Migration, body string) []string {
+	if migration.UpFunc != nil {
+		return []string{"-- Go migration function"}
+	}
+	var statements []string
+	for _, statement := range splitStatements(dialect, body) {
+		statements = append(statements, statement.SQL)
+	}
+	return statements
+}
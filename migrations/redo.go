@@ -0,0 +1,105 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+)
+
+// Redo rolls back the last `steps` applied migrations and applies them again,
+// for iterating on a migration during development. The migration files are
+// read again first, so edits to the up and down files are picked up, and the
+// whole operation holds the migration lock. The migrations are re-applied in
+// the order they were first applied, as a new batch. If steps is less than 1,
+// it defaults to 1.
+//
+// Redo does not check for drift even with Config.ValidateOnMigrate, as the
+// migrations it applies are expected to have changed; their checksums are
+// recorded anew.
+func (m *Migrator) Redo(steps int) error {
+	return m.RedoContext(context.Background(), steps)
+}
+
+// RedoContext is like Redo but binds its transactions to ctx. If ctx is
+// canceled after the rollback has committed, the migrations not yet
+// re-applied are left pending.
+func (m *Migrator) RedoContext(ctx context.Context, steps int) error {
+	if steps < 1 {
+		steps = 1
+	}
+
+	dialect, err := getDialect(m.config.DatabaseType)
+	if err != nil {
+		return err
+	}
+
+	return m.withLock(ctx, dialect, func() error {
+		return m.redo(ctx, dialect, steps)
+	})
+}
+
+// redo rolls back and re-applies the last steps applied migrations; the
+// caller holds the migration lock
+func (m *Migrator) redo(ctx context.Context, dialect Dialect, steps int) error {
+	if err := m.checkDirty(ctx); err != nil {
+		return err
+	}
+	if err := m.LoadMigrationsContext(ctx); err != nil {
+		return err
+	}
+
+	records, err := m.appliedRecords(ctx)
+	if err != nil {
+		return err
+	}
+
+	down, up, err := m.redoMigrations(records, steps)
+	if err != nil {
+		return err
+	}
+
+	if err := m.revertMigrations(ctx, dialect, down); err != nil {
+		return err
+	}
+	recorder := newRecorder(records, redoLatest(records, down))
+	for _, migration := range up {
+		if err := m.applyMigration(ctx, dialect, migration, recorder.next(migration)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// redoMigrations resolves which migrations Redo rolls back, most recently
+// applied first, and the order it then applies them in
+func (m *Migrator) redoMigrations(records []appliedRecord, steps int) (down, up []*Migration, err error) {
+	down, err = m.rollbackMigrations(records, steps)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(down) == 0 {
+		return nil, nil, errors.New("no loaded migrations to redo")
+	}
+
+	for i := len(down) - 1; i >= 0; i-- {
+		up = append(up, down[i])
+	}
+	return down, up, nil
+}
+
+// redoLatest returns the newest version left applied once down is rolled
+// back, below which a re-applied migration is recorded as out of order again
+func redoLatest(records []appliedRecord, down []*Migration) int64 {
+	redone := make(map[int64]bool, len(down))
+	for _, migration := range down {
+		redone[migration.Version] = true
+	}
+
+	var latest int64
+	for _, record := range records {
+		if !redone[record.version] && record.version > latest {
+			latest = record.version
+		}
+	}
+	return latest
+}
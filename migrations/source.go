@@ -0,0 +1,77 @@
+package migrations
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// Source provides the migration files read by LoadMigrations
+type Source interface {
+	// Files returns the names of the files available in the source.
+	Files() ([]string, error)
+	// ReadFile returns the contents of the named file.
+	ReadFile(name string) ([]byte, error)
+}
+
+// DirSource returns a Source that reads migration files from a directory on disk.
+// This is what New uses for its migrationsDir argument.
+func DirSource(dir string) Source {
+	return dirSource{dir: dir}
+}
+
+// FSSource returns a Source that reads migration files from dir within fsys.
+// It allows migrations to be shipped inside the binary with go:embed:
+//
+//	//go:embed migrations/*.sql
+//	var migrationFiles embed.FS
+//
+//	migrator := migrations.NewWithSource(db, migrations.FSSource(migrationFiles, "migrations"), config)
+func FSSource(fsys fs.FS, dir string) Source {
+	return fsSource{fsys: fsys, dir: dir}
+}
+
+type dirSource struct {
+	dir string
+}
+
+func (s dirSource) Files() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	return fileNames(entries), nil
+}
+
+func (s dirSource) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(s.dir, name))
+}
+
+type fsSource struct {
+	fsys fs.FS
+	dir  string
+}
+
+func (s fsSource) Files() ([]string, error) {
+	entries, err := fs.ReadDir(s.fsys, s.dir)
+	if err != nil {
+		return nil, err
+	}
+	return fileNames(entries), nil
+}
+
+func (s fsSource) ReadFile(name string) ([]byte, error) {
+	return fs.ReadFile(s.fsys, path.Join(s.dir, name))
+}
+
+// fileNames returns the names of the regular files in entries, skipping directories
+func fileNames(entries []fs.DirEntry) []string {
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names
+}
@@ -0,0 +1,395 @@
+package migrations
+
+import (
+	"strings"
+)
+
+// Statement is a single SQL statement from a migration file.
+type Statement struct {
+	SQL  string
+	Line int // line of the file the statement starts on, from 1
+}
+
+// StatementSplitter is an optional interface for a Dialect that splits
+// migration files into statements itself. SQL migrations run one statement at
+// a time; dialects without a StatementSplitter are split on semicolons using
+// standard SQL quoting and comments.
+type StatementSplitter interface {
+	SplitStatements(sql string) []Statement
+}
+
+// sqlSyntax describes the lexical features a splitter has to skip over so
+// that semicolons inside them do not end a statement
+type sqlSyntax struct {
+	dollarQuotes       bool // PostgreSQL $tag$ ... $tag$ strings and E'...' escapes
+	nestedComments     bool // PostgreSQL /* /* */ */
+	backslashEscapes   bool // MySQL \' inside string literals
+	hashComments       bool // MySQL # comments
+	executableComments bool // MySQL /*! ... */ is run, not ignored
+	backticks          bool // MySQL `identifiers`
+	brackets           bool // SQL Server and SQLite [identifiers]
+	delimiter          bool // MySQL client DELIMITER directive
+}
+
+var (
+	standardSyntax  = sqlSyntax{}
+	postgresSyntax  = sqlSyntax{dollarQuotes: true, nestedComments: true}
+	mysqlSyntax     = sqlSyntax{backslashEscapes: true, hashComments: true, executableComments: true, backticks: true, delimiter: true}
+	sqliteSyntax    = sqlSyntax{backticks: true, brackets: true}
+	sqlserverSyntax = sqlSyntax{brackets: true}
+)
+
+func (postgresDialect) SplitStatements(sql string) []Statement {
+	return splitSQL(sql, postgresSyntax)
+}
+
+func (mysqlDialect) SplitStatements(sql string) []Statement {
+	return splitSQL(sql, mysqlSyntax)
+}
+
+func (sqliteDialect) SplitStatements(sql string) []Statement {
+	return splitSQL(sql, sqliteSyntax)
+}
+
+func (sqlserverDialect) SplitStatements(sql string) []Statement {
+	return splitSQL(sql, sqlserverSyntax)
+}
+
+// splitStatements splits sql with the dialect's splitter, if it has one
+func splitStatements(dialect Dialect, sql string) []Statement {
+	if splitter, ok := dialect.(StatementSplitter); ok {
+		return splitter.SplitStatements(sql)
+	}
+	return splitSQL(sql, standardSyntax)
+}
+
+// splitSQL splits sql into statements ending in a semicolon, or in the
+// delimiter set with DELIMITER when syntax allows it. Semicolons inside string
+// literals, quoted identifiers, comments and BEGIN ... END or CASE ... END
+// blocks do not end a statement. Statements holding nothing but comments are
+// dropped. Unterminated quotes and comments run to the end of sql and are left
+// for the database to reject.
+func splitSQL(sql string, syntax sqlSyntax) []Statement {
+	s := &sqlSplitter{sql: sql, syntax: syntax, line: 1, delimiter: ";", start: -1}
+	for s.pos < len(sql) {
+		s.next()
+	}
+	s.emit(len(sql))
+	return s.statements
+}
+
+type sqlSplitter struct {
+	sql        string
+	syntax     sqlSyntax
+	pos        int
+	line       int
+	delimiter  string
+	statements []Statement
+
+	start     int // offset of the current statement's first token, or -1
+	startLine int
+	words     int    // keywords and identifiers seen in the current statement
+	first     string // the statement's first word, upper-cased
+	object    string // the kind of object a CREATE or ALTER statement is about
+	previous  string // the word before the current one, upper-cased
+	depth     int    // open BEGIN and CASE blocks
+}
+
+// routineObjects are the objects whose CREATE or ALTER statement holds a body
+// that may contain BEGIN ... END blocks; objectKinds also lists the common
+// objects that cannot, so that a column named event or begin does not open one
+var (
+	routineObjects = map[string]bool{"PROCEDURE": true, "PROC": true, "FUNCTION": true, "TRIGGER": true, "EVENT": true}
+	objectKinds    = map[string]bool{"TABLE": true, "VIEW": true, "INDEX": true, "SEQUENCE": true, "SCHEMA": true,
+		"DATABASE": true, "TYPE": true, "DOMAIN": true, "EXTENSION": true, "ROLE": true, "USER": true, "POLICY": true}
+)
+
+// next consumes one token at s.pos
+func (s *sqlSplitter) next() {
+	sql := s.sql
+	c := sql[s.pos]
+
+	switch {
+	case c == '\n':
+		s.line++
+		s.pos++
+	case c == ' ' || c == '\t' || c == '\r' || c == '\f' || c == '\v':
+		s.pos++
+
+	case s.syntax.delimiter && s.start < 0 && s.atLineStart() && hasPrefixFold(sql[s.pos:], "DELIMITER") &&
+		s.pos+9 < len(sql) && (sql[s.pos+9] == ' ' || sql[s.pos+9] == '\t'):
+		// The directive is handled by the client and never sent to the server
+		end := strings.IndexByte(sql[s.pos:], '\n')
+		if end < 0 {
+			end = len(sql) - s.pos
+		}
+		if delimiter := strings.TrimSpace(sql[s.pos+9 : s.pos+end]); delimiter != "" {
+			s.delimiter = delimiter
+		}
+		s.pos += end
+
+	case strings.HasPrefix(sql[s.pos:], s.delimiter) && (s.delimiter != ";" || s.depth == 0):
+		s.emit(s.pos)
+		s.pos += len(s.delimiter)
+
+	case strings.HasPrefix(sql[s.pos:], "--") || (s.syntax.hashComments && c == '#'):
+		end := strings.IndexByte(sql[s.pos:], '\n')
+		if end < 0 {
+			end = len(sql) - s.pos
+		}
+		s.pos += end
+	case strings.HasPrefix(sql[s.pos:], "/*"):
+		if s.syntax.executableComments && strings.HasPrefix(sql[s.pos:], "/*!") {
+			s.begin()
+		}
+		s.skipBlockComment()
+
+	case c == '\'':
+		s.begin()
+		s.skipQuoted('\'', s.syntax.backslashEscapes)
+	case c == '"':
+		s.begin()
+		s.skipQuoted('"', s.syntax.backslashEscapes)
+	case c == '`' && s.syntax.backticks:
+		s.begin()
+		s.skipQuoted('`', false)
+	case c == '[' && s.syntax.brackets:
+		s.begin()
+		s.skipQuoted(']', false)
+	case c == '$' && s.syntax.dollarQuotes && s.dollarTag() != "":
+		s.begin()
+		s.skipDollarQuoted(s.dollarTag())
+
+	case isWordStart(c):
+		s.begin()
+		s.word()
+	default:
+		s.begin()
+		s.pos++
+	}
+}
+
+// begin marks the current position as the start of a statement, unless one
+// has already started
+func (s *sqlSplitter) begin() {
+	if s.start < 0 {
+		s.start = s.pos
+		s.startLine = s.line
+	}
+}
+
+// emit ends the current statement at end
+func (s *sqlSplitter) emit(end int) {
+	if s.start >= 0 {
+		if text := strings.TrimSpace(s.sql[s.start:end]); text != "" {
+			s.statements = append(s.statements, Statement{SQL: text, Line: s.startLine})
+		}
+	}
+	s.start = -1
+	s.words = 0
+	s.first, s.object, s.previous = "", "", ""
+	s.depth = 0
+}
+
+// word consumes a keyword or identifier, tracking the blocks it opens and closes
+func (s *sqlSplitter) word() {
+	begin := s.pos
+	for s.pos < len(s.sql) && s.isWordChar(s.sql[s.pos]) {
+		s.pos++
+	}
+	word := strings.ToUpper(s.sql[begin:s.pos])
+	qualified := begin > 0 && s.sql[begin-1] == '.'
+	s.words++
+	previous := s.previous
+	s.previous = word
+
+	switch {
+	case s.words == 1:
+		s.first = word
+	case (s.first == "CREATE" || s.first == "ALTER") && s.object == "" && (routineObjects[word] || objectKinds[word]):
+		s.object = word
+	}
+
+	switch {
+	case qualified:
+	case word == "E" && s.syntax.dollarQuotes && s.pos < len(s.sql) && s.sql[s.pos] == '\'':
+		// PostgreSQL escape string
+		s.skipQuoted('\'', true)
+	case word == "BEGIN" && s.words == 1:
+		// A statement starting with BEGIN opens a transaction, unless it is a
+		// SQL Server BEGIN TRY or bare BEGIN ... END block
+		switch strings.ToUpper(s.peekWord()) {
+		case "", "TRANSACTION", "TRAN", "WORK", "DEFERRED", "IMMEDIATE", "EXCLUSIVE",
+			"ISOLATION", "READ", "NOT", "DEFERRABLE", "DISTRIBUTED":
+		default:
+			s.depth++
+		}
+	case word == "BEGIN" && s.opensBlock(begin, previous):
+		s.depth++
+	case word == "CASE":
+		s.depth++
+	case word == "END" && s.depth > 0:
+		switch strings.ToUpper(s.peekWord()) {
+		case "IF", "LOOP", "WHILE", "REPEAT":
+			// MySQL compound statements close with END IF and the like
+		case "CASE":
+			// A MySQL CASE statement closes with END CASE, whose CASE opens nothing
+			s.depth--
+			s.skipWord()
+		default:
+			s.depth--
+		}
+	}
+}
+
+// skipWord consumes the whitespace and word that peekWord returns
+func (s *sqlSplitter) skipWord() {
+	for s.pos < len(s.sql) && strings.IndexByte(" \t\r\n", s.sql[s.pos]) >= 0 {
+		if s.sql[s.pos] == '\n' {
+			s.line++
+		}
+		s.pos++
+	}
+	for s.pos < len(s.sql) && s.isWordChar(s.sql[s.pos]) {
+		s.pos++
+	}
+}
+
+// opensBlock reports whether a BEGIN at offset begin, after the word
+// previous, opens a BEGIN ... END block rather than being an identifier such
+// as a column named begin. Blocks open in the body of a procedure, function,
+// trigger or event, after AS, ATOMIC or a label, as SQL Server's BEGIN TRY and
+// BEGIN CATCH, and in SQL Server IF and WHILE statements.
+func (s *sqlSplitter) opensBlock(begin int, previous string) bool {
+	switch {
+	case routineObjects[s.object]:
+		return true
+	case previous == "AS", previous == "ATOMIC":
+		return true
+	case s.first == "IF", s.first == "WHILE":
+		return true
+	}
+	switch strings.ToUpper(s.peekWord()) {
+	case "TRY", "CATCH":
+		return true
+	}
+	// label: BEGIN
+	before := strings.TrimRight(s.sql[:begin], " \t\r\n")
+	return strings.HasSuffix(before, ":") && !strings.HasSuffix(before, "::")
+}
+
+// peekWord returns the word following the current position, after whitespace
+func (s *sqlSplitter) peekWord() string {
+	pos := s.pos
+	for pos < len(s.sql) && strings.IndexByte(" \t\r\n", s.sql[pos]) >= 0 {
+		pos++
+	}
+	end := pos
+	for end < len(s.sql) && s.isWordChar(s.sql[end]) {
+		end++
+	}
+	return s.sql[pos:end]
+}
+
+// skipQuoted consumes a literal or identifier up to the closing quote. A
+// doubled closing quote stands for itself, as does a backslash-escaped
+// character when backslash is set.
+func (s *sqlSplitter) skipQuoted(closing byte, backslash bool) {
+	s.pos++
+	for s.pos < len(s.sql) {
+		c := s.sql[s.pos]
+		switch {
+		case c == '\n':
+			s.line++
+		case c == '\\' && backslash && s.pos+1 < len(s.sql):
+			s.pos++
+			if s.sql[s.pos] == '\n' {
+				s.line++
+			}
+		case c == closing:
+			if s.pos+1 < len(s.sql) && s.sql[s.pos+1] == closing {
+				s.pos++
+			} else {
+				s.pos++
+				return
+			}
+		}
+		s.pos++
+	}
+}
+
+// skipBlockComment consumes a /* */ comment
+func (s *sqlSplitter) skipBlockComment() {
+	depth := 0
+	for s.pos < len(s.sql) {
+		switch {
+		case strings.HasPrefix(s.sql[s.pos:], "/*") && (depth == 0 || s.syntax.nestedComments):
+			depth++
+			s.pos += 2
+		case strings.HasPrefix(s.sql[s.pos:], "*/"):
+			depth--
+			s.pos += 2
+			if depth == 0 {
+				return
+			}
+		default:
+			if s.sql[s.pos] == '\n' {
+				s.line++
+			}
+			s.pos++
+		}
+	}
+}
+
+// dollarTag returns the $tag$ opening a dollar-quoted string at the current
+// position, or "" if there is none, as in the parameter $1
+func (s *sqlSplitter) dollarTag() string {
+	end := s.pos + 1
+	for end < len(s.sql) && s.sql[end] != '$' {
+		c := s.sql[end]
+		if !isWordStart(c) && (end == s.pos+1 || c < '0' || c > '9') {
+			return ""
+		}
+		end++
+	}
+	if end >= len(s.sql) {
+		return ""
+	}
+	return s.sql[s.pos : end+1]
+}
+
+// skipDollarQuoted consumes a dollar-quoted string opened by tag
+func (s *sqlSplitter) skipDollarQuoted(tag string) {
+	body := s.pos + len(tag)
+	end := strings.Index(s.sql[body:], tag)
+	if end < 0 {
+		end = len(s.sql) - body
+	} else {
+		end += len(tag)
+	}
+	s.line += strings.Count(s.sql[s.pos:body+end], "\n")
+	s.pos = body + end
+}
+
+// atLineStart reports whether only whitespace precedes the current position
+// on its line
+func (s *sqlSplitter) atLineStart() bool {
+	for i := s.pos - 1; i >= 0 && s.sql[i] != '\n'; i-- {
+		if s.sql[i] != ' ' && s.sql[i] != '\t' && s.sql[i] != '\r' {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *sqlSplitter) isWordChar(c byte) bool {
+	return isWordStart(c) || (c >= '0' && c <= '9') || (c == '$' && s.syntax.dollarQuotes)
+}
+
+func isWordStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
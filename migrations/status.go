@@ -0,0 +1,103 @@
+package migrations
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// MigrationState describes where a migration stands relative to the database
+type MigrationState string
+
+const (
+	// StateApplied means the migration is loaded and recorded as applied.
+	StateApplied MigrationState = "applied"
+	// StatePending means the migration is loaded but has not been applied yet.
+	StatePending MigrationState = "pending"
+	// StateMissingFile means the migration is recorded as applied but no
+	// longer exists in the migrator's Source or registered Go migrations.
+	StateMissingFile MigrationState = "missing-file"
+	// StateDirty means the migration failed part way and the database needs
+	// manual repair followed by Force.
+	StateDirty MigrationState = "dirty"
+)
+
+// MigrationStatus is a single entry of the report returned by Status
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	AppliedAt *time.Time
+	State     MigrationState
+
+	// OutOfOrder is set for a migration applied after a newer one, with
+	// Config.AllowOutOfOrder.
+	OutOfOrder bool
+
+	// Batch is the batch the migration was applied in, see RollbackBatch. It
+	// is 0 for pending migrations and those applied by earlier releases.
+	Batch int64
+}
+
+// Status reports every known migration, loaded or recorded in the database,
+// sorted by version. LoadMigrations should be called first, otherwise all
+// applied migrations are reported as missing.
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	return m.StatusContext(context.Background())
+}
+
+// StatusContext is like Status but uses ctx for the database query.
+func (m *Migrator) StatusContext(ctx context.Context) ([]MigrationStatus, error) {
+	records, err := m.appliedRecords(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	recorded := make(map[int64]appliedRecord, len(records))
+	for _, record := range records {
+		recorded[record.version] = record
+	}
+
+	var statuses []MigrationStatus
+	for _, migration := range m.migrations {
+		status := MigrationStatus{
+			Version: migration.Version,
+			Name:    migration.Name,
+			State:   StatePending,
+		}
+		if record, ok := recorded[migration.Version]; ok {
+			appliedAt := record.appliedAt
+			status.AppliedAt = &appliedAt
+			status.State = StateApplied
+			status.OutOfOrder = record.outOfOrder
+			status.Batch = record.batch
+			if record.dirty {
+				status.State = StateDirty
+			}
+			delete(recorded, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+
+	// Whatever is left was applied from a migration that is no longer loaded
+	for _, record := range recorded {
+		appliedAt := record.appliedAt
+		state := StateMissingFile
+		if record.dirty {
+			state = StateDirty
+		}
+		statuses = append(statuses, MigrationStatus{
+			Version:    record.version,
+			Name:       record.name,
+			AppliedAt:  &appliedAt,
+			State:      state,
+			OutOfOrder: record.outOfOrder,
+			Batch:      record.batch,
+		})
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+
+	return statuses, nil
+}
@@ -0,0 +1,122 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Timeouts limit how long a migration's statements may run and wait for
+// locks, so a migration stuck behind a busy table fails instead of stalling
+// the traffic queued up behind it. Zero leaves the database default in place.
+type Timeouts struct {
+	Statement time.Duration
+	Lock      time.Duration
+}
+
+// TimeoutDialect is an optional interface for a Dialect that can apply
+// Timeouts. Config.StatementTimeout, Config.LockTimeout and the timeout
+// directives of migration files are ignored for dialects without it.
+type TimeoutDialect interface {
+	// TimeoutSQL returns statements applying the non-zero timeouts to the
+	// session running a migration, and statements restoring the defaults
+	// afterwards. Timeouts the database cannot enforce are skipped.
+	TimeoutSQL(timeouts Timeouts) (set, reset []string)
+}
+
+// TimeoutSQL sets statement_timeout and lock_timeout. SET is undone along
+// with a rolled back transaction; RESET covers the rest.
+func (postgresDialect) TimeoutSQL(timeouts Timeouts) (set, reset []string) {
+	if timeouts.Statement > 0 {
+		set = append(set, fmt.Sprintf("SET statement_timeout = %d", timeouts.Statement.Milliseconds()))
+		reset = append(reset, "RESET statement_timeout")
+	}
+	if timeouts.Lock > 0 {
+		set = append(set, fmt.Sprintf("SET lock_timeout = %d", timeouts.Lock.Milliseconds()))
+		reset = append(reset, "RESET lock_timeout")
+	}
+	return set, reset
+}
+
+// TimeoutSQL sets max_execution_time, which only applies to SELECT, and the
+// InnoDB row lock and metadata lock waits, which take whole seconds.
+func (mysqlDialect) TimeoutSQL(timeouts Timeouts) (set, reset []string) {
+	if timeouts.Statement > 0 {
+		set = append(set, fmt.Sprintf("SET SESSION max_execution_time = %d", timeouts.Statement.Milliseconds()))
+		reset = append(reset, "SET SESSION max_execution_time = DEFAULT")
+	}
+	if timeouts.Lock > 0 {
+		seconds := int(math.Max(1, math.Ceil(timeouts.Lock.Seconds())))
+		set = append(set,
+			fmt.Sprintf("SET SESSION innodb_lock_wait_timeout = %d", seconds),
+			fmt.Sprintf("SET SESSION lock_wait_timeout = %d", seconds),
+		)
+		reset = append(reset,
+			"SET SESSION innodb_lock_wait_timeout = DEFAULT",
+			"SET SESSION lock_wait_timeout = DEFAULT",
+		)
+	}
+	return set, reset
+}
+
+// TimeoutSQL sets busy_timeout, how long to wait for another connection's
+// lock. SQLite has no statement timeout. The reset restores the go-sqlite3
+// default of 5 seconds.
+func (sqliteDialect) TimeoutSQL(timeouts Timeouts) (set, reset []string) {
+	if timeouts.Lock > 0 {
+		set = append(set, fmt.Sprintf("PRAGMA busy_timeout = %d", timeouts.Lock.Milliseconds()))
+		reset = append(reset, "PRAGMA busy_timeout = 5000")
+	}
+	return set, reset
+}
+
+// TimeoutSQL sets LOCK_TIMEOUT. SQL Server leaves statement timeouts to the
+// client.
+func (sqlserverDialect) TimeoutSQL(timeouts Timeouts) (set, reset []string) {
+	if timeouts.Lock > 0 {
+		set = append(set, fmt.Sprintf("SET LOCK_TIMEOUT %d", timeouts.Lock.Milliseconds()))
+		reset = append(reset, "SET LOCK_TIMEOUT -1")
+	}
+	return set, reset
+}
+
+// timeouts returns the timeouts of a migration direction, falling back to
+// the Config defaults
+func (m *Migrator) timeouts(override Timeouts) Timeouts {
+	timeouts := Timeouts{Statement: m.config.StatementTimeout, Lock: m.config.LockTimeout}
+	if override.Statement > 0 {
+		timeouts.Statement = override.Statement
+	}
+	if override.Lock > 0 {
+		timeouts.Lock = override.Lock
+	}
+	return timeouts
+}
+
+// timeoutSQL returns the dialect's statements for timeouts, if it supports them
+func timeoutSQL(dialect Dialect, timeouts Timeouts) (set, reset []string) {
+	if timeoutDialect, ok := dialect.(TimeoutDialect); ok {
+		return timeoutDialect.TimeoutSQL(timeouts)
+	}
+	return nil, nil
+}
+
+// setTimeouts applies timeouts to db, the transaction or connection a
+// migration runs on, and returns a function restoring the defaults
+func setTimeouts(ctx context.Context, dialect Dialect, db execer, timeouts Timeouts) (reset func() error, err error) {
+	set, resetSQL := timeoutSQL(dialect, timeouts)
+	for _, statement := range set {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return nil, fmt.Errorf("failed to set timeout: %v", err)
+		}
+	}
+	return func() error {
+		for _, statement := range resetSQL {
+			if _, err := db.ExecContext(ctx, statement); err != nil {
+				return fmt.Errorf("failed to reset timeout: %v", err)
+			}
+		}
+		return nil
+	}, nil
+}
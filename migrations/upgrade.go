@@ -0,0 +1,114 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// VersionWideningDialect is an optional interface for a Dialect whose history
+// tables created by earlier releases have a 32-bit version column, too small
+// for timestamp versions such as 20240131120000.
+type VersionWideningDialect interface {
+	// WidenVersionColumn changes the version column of the history table
+	// name, unquoted and possibly schema-qualified, to a 64-bit integer if it
+	// is narrower. Init calls it on every run, so it must only alter the
+	// table when needed.
+	WidenVersionColumn(ctx context.Context, db *sql.DB, name string) error
+}
+
+// WidenVersionColumn alters an integer version column to BIGINT. CockroachDB's
+// INTEGER is already 64-bit unless default_int_size was changed.
+func (d postgresDialect) WidenVersionColumn(ctx context.Context, db *sql.DB, name string) error {
+	schema, table := splitTableName(name)
+	dataType, err := columnType(ctx, db, `
+		SELECT data_type FROM information_schema.columns
+		WHERE table_schema = COALESCE(NULLIF($1, ''), current_schema())
+			AND table_name = $2 AND column_name = 'version'`, schema, table)
+	if err != nil || (dataType != "integer" && dataType != "smallint") {
+		return err
+	}
+	_, err = db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN version TYPE BIGINT", d.QuoteIdentifier(name)))
+	return err
+}
+
+// WidenVersionColumn modifies an INT version column to BIGINT, which keeps
+// its primary key.
+func (d mysqlDialect) WidenVersionColumn(ctx context.Context, db *sql.DB, name string) error {
+	schema, table := splitTableName(name)
+	dataType, err := columnType(ctx, db, `
+		SELECT DATA_TYPE FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = COALESCE(NULLIF(?, ''), DATABASE())
+			AND TABLE_NAME = ? AND COLUMN_NAME = 'version'`, schema, table)
+	if err != nil || (dataType != "int" && dataType != "mediumint" && dataType != "smallint") {
+		return err
+	}
+	_, err = db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s MODIFY version BIGINT NOT NULL", d.QuoteIdentifier(name)))
+	return err
+}
+
+// WidenVersionColumn alters an INT version column to BIGINT. SQL Server cannot
+// alter a primary key column, so the key is dropped and added back in the
+// same transaction.
+func (d sqlserverDialect) WidenVersionColumn(ctx context.Context, db *sql.DB, name string) error {
+	schema, table := splitTableName(name)
+	dataType, err := columnType(ctx, db, `
+		SELECT DATA_TYPE FROM INFORMATION_SCHEMA.COLUMNS
+		WHERE TABLE_SCHEMA = COALESCE(NULLIF(@p1, ''), SCHEMA_NAME())
+			AND TABLE_NAME = @p2 AND COLUMN_NAME = 'version'`, schema, table)
+	if err != nil || (dataType != "int" && dataType != "smallint") {
+		return err
+	}
+
+	quoted := d.QuoteIdentifier(name)
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var key string
+	err = tx.QueryRowContext(ctx,
+		"SELECT name FROM sys.key_constraints WHERE type = 'PK' AND parent_object_id = OBJECT_ID(@p1)", quoted,
+	).Scan(&key)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	statements := []string{fmt.Sprintf("ALTER TABLE %s ALTER COLUMN version BIGINT NOT NULL", quoted)}
+	if key != "" {
+		constraint := d.QuoteIdentifier(key)
+		statements = []string{
+			fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s", quoted, constraint),
+			statements[0],
+			fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s PRIMARY KEY (version)", quoted, constraint),
+		}
+	}
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// columnType runs a query returning the data type of the version column, or
+// "" if the table is not found where the query looks
+func columnType(ctx context.Context, db *sql.DB, query string, args ...interface{}) (string, error) {
+	var dataType string
+	err := db.QueryRowContext(ctx, query, args...).Scan(&dataType)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return strings.ToLower(dataType), err
+}
+
+// splitTableName splits a schema-qualified table name at its last dot
+func splitTableName(name string) (schema, table string) {
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return "", name
+}
@@ -0,0 +1,130 @@
+package migrations
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Checksum returns the SHA-256 of the migration's up and down SQL, as stored in
+// schema_migrations when the migration is applied. Go migrations have no
+// checksum and are never reported as modified.
+func (mg *Migration) Checksum() string {
+	if mg.UpFunc != nil {
+		return ""
+	}
+	h := sha256.New()
+	h.Write([]byte(mg.UpSQL))
+	h.Write([]byte{0})
+	h.Write([]byte(mg.DownSQL))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// DriftKind describes how an applied migration differs from its source
+type DriftKind string
+
+const (
+	// DriftModified means the migration's SQL changed after it was applied.
+	DriftModified DriftKind = "modified"
+	// DriftRenamed means the migration's files were renamed after it was applied.
+	DriftRenamed DriftKind = "renamed"
+	// DriftMissing means the migration's files were deleted after it was applied.
+	DriftMissing DriftKind = "missing"
+)
+
+// Drift is a single applied migration that no longer matches its source
+type Drift struct {
+	Version int64
+	Name    string
+	Kind    DriftKind
+	Detail  string
+}
+
+// DriftError is returned by Validate when applied migrations have drifted
+type DriftError struct {
+	Drifts []Drift
+}
+
+func (e *DriftError) Error() string {
+	var lines []string
+	for _, drift := range e.Drifts {
+		line := fmt.Sprintf("migration %d (%s) %s", drift.Version, drift.Name, drift.Kind)
+		if drift.Detail != "" {
+			line += ": " + drift.Detail
+		}
+		lines = append(lines, line)
+	}
+	return fmt.Sprintf("%d applied migration(s) changed since they were applied:\n  %s",
+		len(e.Drifts), strings.Join(lines, "\n  "))
+}
+
+// Validate checks every applied migration against the loaded migrations and
+// returns a *DriftError if any was edited, renamed or deleted after it was
+// applied. Migrations recorded before checksums were tracked are only checked
+// for renames and deletions. LoadMigrations must be called first.
+func (m *Migrator) Validate() error {
+	return m.ValidateContext(context.Background())
+}
+
+// ValidateContext is like Validate but uses ctx for the database query.
+func (m *Migrator) ValidateContext(ctx context.Context) error {
+	records, err := m.appliedRecords(ctx)
+	if err != nil {
+		return err
+	}
+
+	recorded := make(map[int64]bool, len(records))
+	for _, record := range records {
+		recorded[record.version] = true
+	}
+
+	var drifts []Drift
+	for _, record := range records {
+		migration := m.findMigration(record.version)
+		switch {
+		case migration == nil:
+			drift := Drift{Version: record.version, Name: record.name, Kind: DriftMissing}
+			// A file renamed to a new version shows up as an unapplied migration with the same content
+			if renamed := m.findUnappliedByChecksum(record.checksum, recorded); renamed != nil {
+				drift.Kind = DriftRenamed
+				drift.Detail = fmt.Sprintf("now version %d (%s)", renamed.Version, renamed.Name)
+			}
+			drifts = append(drifts, drift)
+		case !migration.recordedAs(record.name):
+			drifts = append(drifts, Drift{
+				Version: record.version,
+				Name:    record.name,
+				Kind:    DriftRenamed,
+				Detail:  fmt.Sprintf("now named %s", migration.Name),
+			})
+		case record.checksum != "" && migration.Checksum() != record.checksum:
+			drifts = append(drifts, Drift{
+				Version: record.version,
+				Name:    record.name,
+				Kind:    DriftModified,
+				Detail:  fmt.Sprintf("checksum %s, applied as %s", migration.Checksum(), record.checksum),
+			})
+		}
+	}
+
+	if len(drifts) > 0 {
+		return &DriftError{Drifts: drifts}
+	}
+	return nil
+}
+
+// findUnappliedByChecksum returns a loaded, unapplied migration whose checksum
+// matches, or nil
+func (m *Migrator) findUnappliedByChecksum(checksum string, recorded map[int64]bool) *Migration {
+	if checksum == "" {
+		return nil
+	}
+	for _, migration := range m.migrations {
+		if !recorded[migration.Version] && migration.Checksum() == checksum {
+			return migration
+		}
+	}
+	return nil
+}